@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Analyze prints a report of the parsed model and what the
+// processors could achieve on it, without modifying obj.
+func Analyze(obj *objectfile.OBJ) {
+	epsilon := geometryTolerances(obj)[objectfile.Vertex].Epsilon
+
+	analyzeObjects(obj)
+	analyzeMaterials(obj)
+	analyzeMultiMaterials(obj)
+	analyzeDuplicates(obj)
+	analyzeDegenerates(obj, epsilon)
+	analyzeUnreferenced(obj)
+	analyzeBoundingBox(obj)
+	analyzeDrawCalls(obj)
+
+	logInfo(" ")
+}
+
+func analyzeObjects(obj *objectfile.OBJ) {
+	logInfo(" ")
+	logTitle("Objects")
+	for _, child := range obj.Objects {
+		var faces, lines, points int
+		for _, vd := range child.VertexData {
+			switch vd.Type {
+			case objectfile.Face:
+				faces++
+			case objectfile.Line:
+				lines++
+			case objectfile.Point:
+				points++
+			}
+		}
+		material := child.Material
+		if len(material) == 0 {
+			material = "<none>"
+		}
+		logInfo("  %s %-30s %-20s %8s faces %8s lines %8s points", child.Type, child.Name, material, formatInt(faces), formatInt(lines), formatInt(points))
+	}
+}
+
+func analyzeMaterials(obj *objectfile.OBJ) {
+	type usage struct {
+		Material string
+		Objects  int
+		Faces    int
+	}
+	// preserve declaration order, same as Merge
+	materials := make([]*usage, 0)
+	maxFaces := 0
+	for _, child := range obj.Objects {
+		if len(child.VertexData) == 0 {
+			continue
+		}
+		var u *usage
+		for _, m := range materials {
+			if m.Material == child.Material {
+				u = m
+				break
+			}
+		}
+		if u == nil {
+			u = &usage{Material: child.Material}
+			materials = append(materials, u)
+		}
+		u.Objects++
+		for _, vd := range child.VertexData {
+			if vd.Type == objectfile.Face {
+				u.Faces++
+			}
+		}
+		if u.Faces > maxFaces {
+			maxFaces = u.Faces
+		}
+	}
+
+	logInfo(" ")
+	logTitle("Materials")
+	for _, u := range materials {
+		material := u.Material
+		if len(material) == 0 {
+			material = "<none>"
+		}
+		bar := ""
+		if maxFaces > 0 {
+			bar = strings.Repeat("#", int(40*float64(u.Faces)/float64(maxFaces)))
+		}
+		logInfo("  %-30s %6s objects %8s faces  %s", material, formatInt(u.Objects), formatInt(u.Faces), bar)
+	}
+}
+
+func analyzeMultiMaterials(obj *objectfile.OBJ) {
+	// origins in declaration order with all materials their faces use
+	var (
+		origins   = make([]*objectfile.Object, 0)
+		materials = make(map[*objectfile.Object][]string)
+	)
+	addMaterial := func(origin *objectfile.Object, material string) {
+		for _, m := range materials[origin] {
+			if m == material {
+				return
+			}
+		}
+		materials[origin] = append(materials[origin], material)
+	}
+	for _, child := range obj.Objects {
+		if child.Origin == nil {
+			continue
+		}
+		if _, found := materials[child.Origin]; !found {
+			origins = append(origins, child.Origin)
+			addMaterial(child.Origin, child.Origin.Material)
+		}
+		addMaterial(child.Origin, child.Material)
+	}
+
+	logInfo(" ")
+	logTitle("Multi-material objects")
+	if len(origins) == 0 {
+		logInfo("  None found")
+		return
+	}
+	for _, origin := range origins {
+		logInfo("  %s %-30s %d materials: %s", origin.Type, origin.Name, len(materials[origin]), strings.Join(materials[origin], ", "))
+	}
+}
+
+func analyzeDuplicates(obj *objectfile.OBJ) {
+	var (
+		results    = make(map[objectfile.Type]*replacerResults)
+		mResults   = sync.Mutex{}
+		wg         = &sync.WaitGroup{}
+		stats      = obj.Geometry.Stats()
+		tolerances = geometryTolerances(obj)
+	)
+	setResults := func(result *replacerResults) {
+		mResults.Lock()
+		results[result.Type] = result
+		mResults.Unlock()
+	}
+	// findDuplicates only reads the geometry, it is safe to run without applying the results.
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
+		if slice := obj.Geometry.Get(t); len(slice) > 0 {
+			wg.Add(1)
+			go findDuplicates(withWorkers(context.Background(), StartParams.Workers), t, slice, tolerances[t].Equals(), wg, silentProgress{}, setResults)
+		}
+	}
+	wg.Wait()
+
+	logInfo(" ")
+	logTitle("Duplicates")
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
+		if stats.Num(t) == 0 {
+			continue
+		}
+		duplicates := 0
+		if result := results[t]; result != nil {
+			duplicates = result.Duplicates()
+		}
+		logResultsPostfix(t.Name(), formatInt(duplicates)+" / "+formatInt(stats.Num(t)), fmt.Sprintf("%-10s with %s", computeFloatPerc(float64(duplicates), float64(stats.Num(t)))+"%%", tolerances[t]))
+	}
+}
+
+func analyzeDegenerates(obj *objectfile.OBJ, epsilon float64) {
+	var (
+		collapsed int // repeats the same vertex index
+		zeroArea  int // unique vertex indexes but no area
+		tooFew    int // less than 3 corners declared
+	)
+	for _, child := range obj.Objects {
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				continue
+			}
+			if len(vd.Declarations) < 3 {
+				tooFew++
+			} else if vd.NumUniqueVertices() < len(vd.Declarations) {
+				collapsed++
+			} else if vd.Area() <= epsilon*epsilon {
+				zeroArea++
+			}
+		}
+	}
+
+	logInfo(" ")
+	logTitle("Degenerate faces")
+	logResults("Collapsed", formatInt(collapsed))
+	logResults("Zero area", formatInt(zeroArea))
+	logResults("Under 3 corners", formatInt(tooFew))
+}
+
+func analyzeUnreferenced(obj *objectfile.OBJ) {
+	referenced := map[objectfile.Type]map[*objectfile.GeometryValue]bool{
+		objectfile.Vertex: make(map[*objectfile.GeometryValue]bool),
+		objectfile.Normal: make(map[*objectfile.GeometryValue]bool),
+		objectfile.UV:     make(map[*objectfile.GeometryValue]bool),
+	}
+	for _, child := range obj.Objects {
+		for _, vd := range child.VertexData {
+			for _, decl := range vd.Declarations {
+				if decl.RefVertex != nil {
+					referenced[objectfile.Vertex][decl.RefVertex] = true
+				}
+				if decl.RefNormal != nil {
+					referenced[objectfile.Normal][decl.RefNormal] = true
+				}
+				if decl.RefUV != nil {
+					referenced[objectfile.UV][decl.RefUV] = true
+				}
+			}
+		}
+	}
+
+	logInfo(" ")
+	logTitle("Unreferenced geometry")
+	stats := obj.Geometry.Stats()
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV} {
+		if stats.Num(t) == 0 {
+			continue
+		}
+		unreferenced := stats.Num(t) - len(referenced[t])
+		logResultsPostfix(t.Name(), formatInt(unreferenced)+" / "+formatInt(stats.Num(t)), computeFloatPerc(float64(unreferenced), float64(stats.Num(t)))+"%%")
+	}
+	if stats.Params > 0 {
+		// vp is only referenced by free-form geometry, which is not supported
+		logResultsPostfix(objectfile.Param.Name(), formatInt(stats.Params), "not referenced by f/l/p")
+	}
+}
+
+func analyzeBoundingBox(obj *objectfile.OBJ) {
+	logInfo(" ")
+	logTitle("Bounding box")
+	bb := obj.Geometry.BoundingBox()
+	if bb.Empty {
+		logInfo("  No vertices declared")
+		return
+	}
+	formatVector := func(v objectfile.Vector) string {
+		return fmt.Sprintf("%g %g %g", v.X, v.Y, v.Z)
+	}
+	logResults("Min", formatVector(bb.Min))
+	logResults("Max", formatVector(bb.Max))
+	logResults("Size", formatVector(bb.Size()))
+	logResults("Diagonal", strconv.FormatFloat(bb.Diagonal(), 'g', -1, 64))
+}
+
+func analyzeDrawCalls(obj *objectfile.OBJ) {
+	// each object with vertex data is a mesh, Merge produces one per material
+	var (
+		before    int
+		materials = make(map[string]bool)
+	)
+	for _, child := range obj.Objects {
+		if len(child.VertexData) > 0 {
+			before++
+			materials[child.Material] = true
+		}
+	}
+	after := len(materials)
+	for _, processor := range Processors {
+		if _, isMerge := processor.Processor.(Merge); isMerge && !isInPipeline(processor) {
+			after = before
+		}
+	}
+	logInfo(" ")
+	logTitle("Estimated draw calls")
+	logResults("Before", formatInt(before))
+	logResultsIntPostfix("After", after, computeStatsDiff(before, after))
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// ColladaEncoder writes COLLADA 1.4.1. Each object is a geometry with its own position,
+// normal and texcoord sources and a polylist that indexes them separately like OBJ.
+// MTL materials are converted to phong effects.
+type ColladaEncoder struct{}
+
+func (enc ColladaEncoder) Name() string {
+	return "collada"
+}
+
+func (enc ColladaEncoder) Desc() string {
+	return "COLLADA 1.4.1 with phong materials"
+}
+
+func (enc ColladaEncoder) Extensions() []string {
+	return []string{".dae"}
+}
+
+// colladaSource is the geometry of one type that an object references,
+// re-indexed to start from 0.
+type colladaSource struct {
+	values []*objectfile.GeometryValue
+	local  map[*objectfile.GeometryValue]int
+}
+
+// Returns the local index of gv. Corners that did not declare
+// the type reference a zero value.
+func (s *colladaSource) index(gv *objectfile.GeometryValue) int {
+	if index, ok := s.local[gv]; ok {
+		return index
+	}
+	index := len(s.values)
+	s.local[gv] = index
+	if gv == nil {
+		gv = &objectfile.GeometryValue{}
+	}
+	s.values = append(s.values, gv)
+	return index
+}
+
+func (enc ColladaEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		bw      = bufio.NewWriter(wc)
+		lw      = &lineCountingWriter{w: bw}
+		skipped = 0
+		ids     = map[string]bool{"Scene": true}
+		// material ids by OBJ material name
+		materialIds = make(map[string]string)
+		materials   []string
+		geometryIds = make([]string, len(obj.Objects))
+	)
+	for i, child := range obj.Objects {
+		geometryIds[i] = uniqueIdentifier(child.Name, ids)
+		if len(child.Material) > 0 && len(materialIds[child.Material]) == 0 {
+			materialIds[child.Material] = uniqueIdentifier(child.Material, ids)
+			materials = append(materials, child.Material)
+		}
+	}
+
+	// the created time is required, -deterministic writes a fixed one
+	created := time.Now().UTC().Format(time.RFC3339)
+	if StartParams.Deterministic {
+		created = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	}
+	fmt.Fprint(lw, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprint(lw, "<COLLADA xmlns=\"http://www.collada.org/2005/11/COLLADASchema\" version=\"1.4.1\">\n")
+	fmt.Fprint(lw, "  <asset>\n")
+	fmt.Fprintf(lw, "    <contributor>\n      <authoring_tool>%s %s</authoring_tool>\n", ApplicationName, xmlEscape(getVersion(false)))
+	if !StartParams.NoHeader {
+		fmt.Fprintf(lw, "      <comments>%s</comments>\n", xmlEscape(headerComment()))
+	}
+	fmt.Fprint(lw, "    </contributor>\n")
+	fmt.Fprintf(lw, "    <created>%s</created>\n    <modified>%s</modified>\n", created, created)
+	fmt.Fprint(lw, "    <up_axis>Y_UP</up_axis>\n")
+	fmt.Fprint(lw, "  </asset>\n")
+
+	if len(materials) > 0 {
+		writeColladaMaterials(lw, obj, materials, materialIds, path)
+	}
+
+	fmt.Fprint(lw, "  <library_geometries>\n")
+	written := make([]bool, len(obj.Objects))
+	for i, child := range obj.Objects {
+		var (
+			positions   = &colladaSource{local: make(map[*objectfile.GeometryValue]int)}
+			normals     = &colladaSource{local: make(map[*objectfile.GeometryValue]int)}
+			uvs         = &colladaSource{local: make(map[*objectfile.GeometryValue]int)}
+			hasNormals  bool
+			hasUVs      bool
+			vcount, p   []string
+			faces       = 0
+			id          = geometryIds[i]
+			faceCorners []*objectfile.Declaration
+		)
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				skipped++
+				continue
+			}
+			faces++
+			vcount = append(vcount, intToString(len(vd.Declarations)))
+			for _, decl := range vd.Declarations {
+				faceCorners = append(faceCorners, decl)
+				hasNormals = hasNormals || decl.RefNormal != nil
+				hasUVs = hasUVs || decl.RefUV != nil
+			}
+		}
+		if faces == 0 {
+			continue
+		}
+		written[i] = true
+		for _, decl := range faceCorners {
+			p = append(p, intToString(positions.index(decl.RefVertex)))
+			if hasNormals {
+				p = append(p, intToString(normals.index(decl.RefNormal)))
+			}
+			if hasUVs {
+				p = append(p, intToString(uvs.index(decl.RefUV)))
+			}
+		}
+
+		fmt.Fprintf(lw, "    <geometry id=\"%s-mesh\" name=\"%s\">\n", id, xmlEscape(child.Name))
+		fmt.Fprint(lw, "      <mesh>\n")
+		writeColladaSource(lw, id+"-positions", objectfile.Vertex, positions.values, "X", "Y", "Z")
+		if hasNormals {
+			writeColladaSource(lw, id+"-normals", objectfile.Normal, normals.values, "X", "Y", "Z")
+		}
+		if hasUVs {
+			writeColladaSource(lw, id+"-map", objectfile.UV, uvs.values, "S", "T")
+		}
+		fmt.Fprintf(lw, "        <vertices id=\"%s-vertices\">\n", id)
+		fmt.Fprintf(lw, "          <input semantic=\"POSITION\" source=\"#%s-positions\"/>\n", id)
+		fmt.Fprint(lw, "        </vertices>\n")
+		if len(child.Material) > 0 {
+			fmt.Fprintf(lw, "        <polylist material=\"%s-material\" count=\"%d\">\n", materialIds[child.Material], faces)
+		} else {
+			fmt.Fprintf(lw, "        <polylist count=\"%d\">\n", faces)
+		}
+		offset := 0
+		fmt.Fprintf(lw, "          <input semantic=\"VERTEX\" source=\"#%s-vertices\" offset=\"%d\"/>\n", id, offset)
+		if hasNormals {
+			offset++
+			fmt.Fprintf(lw, "          <input semantic=\"NORMAL\" source=\"#%s-normals\" offset=\"%d\"/>\n", id, offset)
+		}
+		if hasUVs {
+			offset++
+			fmt.Fprintf(lw, "          <input semantic=\"TEXCOORD\" source=\"#%s-map\" offset=\"%d\" set=\"0\"/>\n", id, offset)
+		}
+		fmt.Fprintf(lw, "          <vcount>%s</vcount>\n", strings.Join(vcount, " "))
+		fmt.Fprintf(lw, "          <p>%s</p>\n", strings.Join(p, " "))
+		fmt.Fprint(lw, "        </polylist>\n")
+		fmt.Fprint(lw, "      </mesh>\n")
+		fmt.Fprint(lw, "    </geometry>\n")
+	}
+	fmt.Fprint(lw, "  </library_geometries>\n")
+	if skipped > 0 {
+		logWarn("COLLADA output does not support lines and points, %d declarations not written", skipped)
+	}
+
+	fmt.Fprint(lw, "  <library_visual_scenes>\n")
+	fmt.Fprint(lw, "    <visual_scene id=\"Scene\" name=\"Scene\">\n")
+	for i, child := range obj.Objects {
+		if !written[i] {
+			continue
+		}
+		id := geometryIds[i]
+		fmt.Fprintf(lw, "      <node id=\"%s\" name=\"%s\" type=\"NODE\">\n", id, xmlEscape(child.Name))
+		fmt.Fprintf(lw, "        <instance_geometry url=\"#%s-mesh\" name=\"%s\">\n", id, xmlEscape(child.Name))
+		if len(child.Material) > 0 {
+			materialId := materialIds[child.Material]
+			fmt.Fprint(lw, "          <bind_material>\n            <technique_common>\n")
+			fmt.Fprintf(lw, "              <instance_material symbol=\"%s-material\" target=\"#%s-material\">\n", materialId, materialId)
+			fmt.Fprint(lw, "                <bind_vertex_input semantic=\"UVMap\" input_semantic=\"TEXCOORD\" input_set=\"0\"/>\n")
+			fmt.Fprint(lw, "              </instance_material>\n")
+			fmt.Fprint(lw, "            </technique_common>\n          </bind_material>\n")
+		}
+		fmt.Fprint(lw, "        </instance_geometry>\n")
+		fmt.Fprint(lw, "      </node>\n")
+	}
+	fmt.Fprint(lw, "    </visual_scene>\n")
+	fmt.Fprint(lw, "  </library_visual_scenes>\n")
+	fmt.Fprint(lw, "  <scene>\n    <instance_visual_scene url=\"#Scene\"/>\n  </scene>\n")
+	fmt.Fprint(lw, "</COLLADA>\n")
+
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return lw.lines, wc.Close()
+}
+
+func writeColladaSource(w io.Writer, id string, t objectfile.Type, values []*objectfile.GeometryValue, params ...string) {
+	ff := StartParams.FloatFormatFor(t)
+	floats := make([]string, 0, len(values)*len(params))
+	for _, gv := range values {
+		floats = append(floats, []string{ff.Format(gv.X), ff.Format(gv.Y), ff.Format(gv.Z)}[0:len(params)]...)
+	}
+	fmt.Fprintf(w, "        <source id=\"%s\">\n", id)
+	fmt.Fprintf(w, "          <float_array id=\"%s-array\" count=\"%d\">%s</float_array>\n", id, len(floats), strings.Join(floats, " "))
+	fmt.Fprint(w, "          <technique_common>\n")
+	fmt.Fprintf(w, "            <accessor source=\"#%s-array\" count=\"%d\" stride=\"%d\">\n", id, len(values), len(params))
+	for _, param := range params {
+		fmt.Fprintf(w, "              <param name=\"%s\" type=\"float\"/>\n", param)
+	}
+	fmt.Fprint(w, "            </accessor>\n")
+	fmt.Fprint(w, "          </technique_common>\n")
+	fmt.Fprint(w, "        </source>\n")
+}
+
+// Writes the images, effects and materials libraries.
+func writeColladaMaterials(w io.Writer, obj *objectfile.OBJ, materials []string, materialIds map[string]string, output string) {
+	var (
+		ff = objectfile.DefaultFloatFormat
+		// diffuse texture paths by material id
+		textures = make(map[string]string)
+	)
+	for _, name := range materials {
+		if material := obj.Material(name); material != nil {
+			if p := material.Property("map_Kd"); p != nil && len(p.TexturePath()) > 0 {
+				textures[materialIds[name]] = outputTexturePath(material, p.TexturePath(), output)
+			}
+		} else {
+			logWarn("Material %q not found from the mtllib files, writing a default COLLADA effect", name)
+		}
+	}
+
+	if len(textures) > 0 {
+		fmt.Fprint(w, "  <library_images>\n")
+		for _, name := range materials {
+			id := materialIds[name]
+			if texture, ok := textures[id]; ok {
+				fmt.Fprintf(w, "    <image id=\"%s-image\">\n      <init_from>%s</init_from>\n    </image>\n", id, xmlEscape(texture))
+			}
+		}
+		fmt.Fprint(w, "  </library_images>\n")
+	}
+
+	fmt.Fprint(w, "  <library_effects>\n")
+	for _, name := range materials {
+		var (
+			id       = materialIds[name]
+			material = obj.Material(name)
+			floats   = func(key string) []float64 {
+				if material == nil {
+					return nil
+				}
+				return material.Floats(key)
+			}
+			color = func(element, key string) {
+				if values := floats(key); len(values) >= 3 {
+					fmt.Fprintf(w, "            <%s>\n              <color>%s %s %s 1</color>\n            </%s>\n", element, ff.Format(values[0]), ff.Format(values[1]), ff.Format(values[2]), element)
+				}
+			}
+		)
+		fmt.Fprintf(w, "    <effect id=\"%s-effect\">\n", id)
+		fmt.Fprint(w, "      <profile_COMMON>\n")
+		_, hasTexture := textures[id]
+		if hasTexture {
+			fmt.Fprintf(w, "        <newparam sid=\"%s-surface\">\n          <surface type=\"2D\">\n            <init_from>%s-image</init_from>\n          </surface>\n        </newparam>\n", id, id)
+			fmt.Fprintf(w, "        <newparam sid=\"%s-sampler\">\n          <sampler2D>\n            <source>%s-surface</source>\n          </sampler2D>\n        </newparam>\n", id, id)
+		}
+		fmt.Fprint(w, "        <technique sid=\"common\">\n")
+		fmt.Fprint(w, "          <phong>\n")
+		color("emission", "Ke")
+		color("ambient", "Ka")
+		if hasTexture {
+			fmt.Fprintf(w, "            <diffuse>\n              <texture texture=\"%s-sampler\" texcoord=\"UVMap\"/>\n            </diffuse>\n", id)
+		} else {
+			color("diffuse", "Kd")
+		}
+		color("specular", "Ks")
+		if values := floats("Ns"); len(values) > 0 {
+			fmt.Fprintf(w, "            <shininess>\n              <float>%s</float>\n            </shininess>\n", ff.Format(values[0]))
+		}
+		if values := floats("d"); len(values) > 0 {
+			fmt.Fprintf(w, "            <transparency>\n              <float>%s</float>\n            </transparency>\n", ff.Format(values[0]))
+		} else if values := floats("Tr"); len(values) > 0 {
+			fmt.Fprintf(w, "            <transparency>\n              <float>%s</float>\n            </transparency>\n", ff.Format(1-values[0]))
+		}
+		if values := floats("Ni"); len(values) > 0 {
+			fmt.Fprintf(w, "            <index_of_refraction>\n              <float>%s</float>\n            </index_of_refraction>\n", ff.Format(values[0]))
+		}
+		fmt.Fprint(w, "          </phong>\n")
+		fmt.Fprint(w, "        </technique>\n")
+		fmt.Fprint(w, "      </profile_COMMON>\n")
+		fmt.Fprint(w, "    </effect>\n")
+	}
+	fmt.Fprint(w, "  </library_effects>\n")
+
+	fmt.Fprint(w, "  <library_materials>\n")
+	for _, name := range materials {
+		id := materialIds[name]
+		fmt.Fprintf(w, "    <material id=\"%s-material\" name=\"%s\">\n      <instance_effect url=\"#%s-effect\"/>\n    </material>\n", id, xmlEscape(name), id)
+	}
+	fmt.Fprint(w, "  </library_materials>\n")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// compare command: measures how much two models differ, eg. a source
+// model and its simplified output. Exits with 1 if thresholds are exceeded.
+
+func runCompare(cmd *command, args []string) int {
+	fs := cmd.FlagSet()
+	options := StartParams.VerifyOptions
+	registerOptions(fs, &options)
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON.")
+	fs.Parse(args)
+
+	initLogging(false)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	if err := options.Validate(); err != nil {
+		logRaw("%s", err)
+		return 2
+	}
+	// only the JSON document is printed
+	StartParams.Quiet = *jsonOutput
+
+	surfaces := make([]*surface, 0, 2)
+	for _, path := range fs.Args() {
+		obj, _, _, err := parseInput(path)
+		if err != nil {
+			logRaw("%s: %s", path, err)
+			return 1
+		}
+		surfaces = append(surfaces, newSurface(obj))
+	}
+	result, err := compareSurfaces(withWorkers(context.Background(), StartParams.Workers), surfaces[0], surfaces[1], fs.Arg(0), fs.Arg(1), options)
+	if err != nil {
+		logRaw("%s", err)
+		return 1
+	}
+	if *jsonOutput {
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logRaw("%s", err)
+			return 1
+		}
+		fmt.Println(string(b))
+	} else {
+		logInfo("%s -> %s", fs.Arg(0), fs.Arg(1))
+		logCompareResult(result)
+		logInfo(" ")
+	}
+	if len(result.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
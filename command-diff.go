@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// diff command: reports structural changes between two models eg. two exports
+// of the same scene. Objects are matched by their declared name and material.
+
+const (
+	diffAdded    = "added"
+	diffRemoved  = "removed"
+	diffModified = "modified"
+)
+
+// DiffResult lists the objects and materials that differ, unchanged ones are counted.
+type DiffResult struct {
+	A                  string          `json:"a"`
+	B                  string          `json:"b"`
+	Objects            []*ObjectDiff   `json:"objects"`
+	Materials          []*MaterialDiff `json:"materials"`
+	UnchangedObjects   int             `json:"unchanged_objects"`
+	UnchangedMaterials int             `json:"unchanged_materials"`
+}
+
+type ObjectDiff struct {
+	Change string `json:"change"`
+	Name   string `json:"name"`
+	// nil for added objects
+	Before *diffObject `json:"before,omitempty"`
+	// nil for removed objects
+	After *diffObject `json:"after,omitempty"`
+	// Human readable changes of modified objects.
+	Changes []string `json:"changes,omitempty"`
+}
+
+type MaterialDiff struct {
+	Change     string          `json:"change"`
+	Name       string          `json:"name"`
+	Properties []*PropertyDiff `json:"properties,omitempty"`
+}
+
+type PropertyDiff struct {
+	Change string `json:"change"`
+	Key    string `json:"key"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// diffObject is an object as declared in the file. Objects that the parser
+// split by usemtl are combined per material.
+type diffObject struct {
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	Material string     `json:"material"`
+	Faces    int        `json:"faces"`
+	Lines    int        `json:"lines"`
+	Points   int        `json:"points"`
+	Vertices int        `json:"vertices"`
+	Min      [3]float64 `json:"min"`
+	Max      [3]float64 `json:"max"`
+
+	box      objectfile.BoundingBox
+	vertices map[int]bool
+	matched  bool
+}
+
+func runDiff(cmd *command, args []string) int {
+	fs := cmd.FlagSet()
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON.")
+	epsilon := fs.Float64("epsilon", 1e-6, "Bounding box and numeric material values that differ less than this are equal.")
+	fs.Parse(args)
+
+	initLogging(false)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	// only the JSON document is printed
+	StartParams.Quiet = *jsonOutput
+
+	objs := make([]*objectfile.OBJ, 0, 2)
+	for _, path := range fs.Args() {
+		obj, _, _, err := parseInput(path)
+		if err != nil {
+			logRaw("%s: %s", path, err)
+			return 2
+		}
+		objs = append(objs, obj)
+	}
+	result := diffOBJ(objs[0], objs[1], *epsilon)
+	result.A, result.B = fs.Arg(0), fs.Arg(1)
+
+	if *jsonOutput {
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logRaw("%s", err)
+			return 2
+		}
+		fmt.Println(string(b))
+	} else {
+		logDiffResult(result)
+	}
+	// same as diff(1)
+	if len(result.Objects) > 0 || len(result.Materials) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func diffOBJ(a, b *objectfile.OBJ, epsilon float64) *DiffResult {
+	result := &DiffResult{
+		Objects:   make([]*ObjectDiff, 0),
+		Materials: make([]*MaterialDiff, 0),
+	}
+	before, after := diffObjects(a), diffObjects(b)
+
+	pair := func(o, other *diffObject) {
+		o.matched, other.matched = true, true
+		if changes := o.Changes(other, epsilon); len(changes) > 0 {
+			result.Objects = append(result.Objects, &ObjectDiff{Change: diffModified, Name: o.Name, Before: o, After: other, Changes: changes})
+		} else {
+			result.UnchangedObjects++
+		}
+	}
+	// same name and material, then same name with a reassigned material
+	for _, sameMaterial := range []bool{true, false} {
+		for _, o := range before {
+			for _, other := range after {
+				if !o.matched && !other.matched && o.Name == other.Name && (!sameMaterial || o.Material == other.Material) {
+					pair(o, other)
+				}
+			}
+		}
+	}
+	for _, o := range before {
+		if !o.matched {
+			result.Objects = append(result.Objects, &ObjectDiff{Change: diffRemoved, Name: o.Name, Before: o})
+		}
+	}
+	for _, o := range after {
+		if !o.matched {
+			result.Objects = append(result.Objects, &ObjectDiff{Change: diffAdded, Name: o.Name, After: o})
+		}
+	}
+
+	// materials of the loaded libraries by name
+	for _, m := range a.Materials {
+		other := b.Material(m.Name)
+		if other == nil {
+			result.Materials = append(result.Materials, &MaterialDiff{Change: diffRemoved, Name: m.Name})
+		} else if properties := diffMaterialProperties(m, other, epsilon); len(properties) > 0 {
+			result.Materials = append(result.Materials, &MaterialDiff{Change: diffModified, Name: m.Name, Properties: properties})
+		} else {
+			result.UnchangedMaterials++
+		}
+	}
+	for _, m := range b.Materials {
+		if a.Material(m.Name) == nil {
+			result.Materials = append(result.Materials, &MaterialDiff{Change: diffAdded, Name: m.Name})
+		}
+	}
+	return result
+}
+
+// Returns the objects of obj as declared, in declaration order.
+func diffObjects(obj *objectfile.OBJ) []*diffObject {
+	objects := make([]*diffObject, 0, len(obj.Objects))
+	for _, child := range obj.Objects {
+		declared := child
+		if child.Origin != nil {
+			declared = child.Origin
+		}
+		var o *diffObject
+		for _, existing := range objects {
+			if existing.Name == declared.Name && existing.Material == child.Material {
+				o = existing
+				break
+			}
+		}
+		if o == nil {
+			o = &diffObject{
+				Type:     declared.Type.String(),
+				Name:     declared.Name,
+				Material: child.Material,
+				box:      objectfile.NewBoundingBox(),
+				vertices: make(map[int]bool),
+			}
+			objects = append(objects, o)
+		}
+		o.Add(child)
+	}
+	// drop empty entries of objects that declared their geometry after usemtl
+	hasGeometry := make(map[string]bool)
+	for _, o := range objects {
+		hasGeometry[o.Name] = hasGeometry[o.Name] || len(o.vertices) > 0
+	}
+	declared := objects[:0]
+	for _, o := range objects {
+		if len(o.vertices) > 0 || !hasGeometry[o.Name] {
+			declared = append(declared, o)
+		}
+	}
+	return declared
+}
+
+func (o *diffObject) Add(child *objectfile.Object) {
+	for _, vd := range child.VertexData {
+		switch vd.Type {
+		case objectfile.Face:
+			o.Faces++
+		case objectfile.Line:
+			o.Lines++
+		case objectfile.Point:
+			o.Points++
+		}
+		for _, decl := range vd.Declarations {
+			o.vertices[decl.Index(objectfile.Vertex)] = true
+			if decl.RefVertex != nil {
+				o.box.Expand(decl.RefVertex.Vector())
+			}
+		}
+	}
+	o.Vertices = len(o.vertices)
+	if !o.box.Empty {
+		o.Min = [3]float64{o.box.Min.X, o.box.Min.Y, o.box.Min.Z}
+		o.Max = [3]float64{o.box.Max.X, o.box.Max.Y, o.box.Max.Z}
+	}
+}
+
+// Returns the differences to other eg. "faces 120 -> 118".
+func (o *diffObject) Changes(other *diffObject, epsilon float64) (changes []string) {
+	if o.Type != other.Type {
+		changes = append(changes, fmt.Sprintf("type %s -> %s", o.Type, other.Type))
+	}
+	if o.Material != other.Material {
+		changes = append(changes, fmt.Sprintf("material %s -> %s", materialLabel(o.Material), materialLabel(other.Material)))
+	}
+	for _, count := range []struct {
+		name          string
+		before, after int
+	}{
+		{"faces", o.Faces, other.Faces},
+		{"lines", o.Lines, other.Lines},
+		{"points", o.Points, other.Points},
+		{"vertices", o.Vertices, other.Vertices},
+	} {
+		if count.before != count.after {
+			changes = append(changes, fmt.Sprintf("%s %d -> %d", count.name, count.before, count.after))
+		}
+	}
+	if !vectorEquals(o.Min, other.Min, epsilon) || !vectorEquals(o.Max, other.Max, epsilon) {
+		changes = append(changes, fmt.Sprintf("bounding box %s -> %s", formatBox(o.Min, o.Max), formatBox(other.Min, other.Max)))
+	}
+	return changes
+}
+
+func vectorEquals(a, b [3]float64, epsilon float64) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+func formatBox(min, max [3]float64) string {
+	return fmt.Sprintf("(%g %g %g)-(%g %g %g)", min[0], min[1], min[2], max[0], max[1], max[2])
+}
+
+// Compares the last declared value of each property, keys are case insensitive.
+func diffMaterialProperties(a, b *objectfile.Material, epsilon float64) []*PropertyDiff {
+	var diffs []*PropertyDiff
+	for _, key := range materialKeys(a) {
+		p := b.Property(key)
+		if p == nil {
+			diffs = append(diffs, &PropertyDiff{Change: diffRemoved, Key: key, Before: a.Get(key)})
+		} else if !propertyValueEquals(a.Get(key), p.Value, epsilon) {
+			diffs = append(diffs, &PropertyDiff{Change: diffModified, Key: key, Before: a.Get(key), After: p.Value})
+		}
+	}
+	for _, key := range materialKeys(b) {
+		if a.Property(key) == nil {
+			diffs = append(diffs, &PropertyDiff{Change: diffAdded, Key: key, After: b.Get(key)})
+		}
+	}
+	return diffs
+}
+
+// Returns the property keys in declaration order without duplicates.
+func materialKeys(m *objectfile.Material) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, p := range m.Properties {
+		if lower := strings.ToLower(p.Key); !seen[lower] {
+			seen[lower] = true
+			keys = append(keys, p.Key)
+		}
+	}
+	return keys
+}
+
+// Values are compared by whitespace separated fields, numbers within epsilon.
+func propertyValueEquals(a, b string, epsilon float64) bool {
+	fieldsA, fieldsB := strings.Fields(a), strings.Fields(b)
+	if len(fieldsA) != len(fieldsB) {
+		return false
+	}
+	for i := range fieldsA {
+		if fieldsA[i] == fieldsB[i] {
+			continue
+		}
+		numA, errA := strconv.ParseFloat(fieldsA[i], 64)
+		numB, errB := strconv.ParseFloat(fieldsB[i], 64)
+		if errA != nil || errB != nil || math.Abs(numA-numB) > epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+func logDiffResult(r *DiffResult) {
+	logRaw("--- %s", r.A)
+	logRaw("+++ %s", r.B)
+
+	symbols := map[string]string{diffAdded: "+", diffRemoved: "-", diffModified: "~"}
+	if len(r.Objects) > 0 {
+		logRaw(" ")
+		logRaw("Objects")
+	}
+	for _, o := range r.Objects {
+		switch o.Change {
+		case diffAdded:
+			logRaw("  + %s", o.After)
+		case diffRemoved:
+			logRaw("  - %s", o.Before)
+		default:
+			logRaw("  ~ %s %s [%s]", o.Before.Type, o.Name, materialLabel(o.Before.Material))
+			for _, change := range o.Changes {
+				logRaw("      %s", change)
+			}
+		}
+	}
+	if len(r.Materials) > 0 {
+		logRaw(" ")
+		logRaw("Materials")
+	}
+	for _, m := range r.Materials {
+		logRaw("  %s %s", symbols[m.Change], m.Name)
+		for _, p := range m.Properties {
+			switch p.Change {
+			case diffAdded:
+				logRaw("      + %s %s", p.Key, p.After)
+			case diffRemoved:
+				logRaw("      - %s %s", p.Key, p.Before)
+			default:
+				logRaw("      ~ %s %s -> %s", p.Key, p.Before, p.After)
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, o := range r.Objects {
+		counts["o"+o.Change]++
+	}
+	for _, m := range r.Materials {
+		counts["m"+m.Change]++
+	}
+	logRaw(" ")
+	logRaw("Objects:   %d added, %d removed, %d modified, %d unchanged", counts["o"+diffAdded], counts["o"+diffRemoved], counts["o"+diffModified], r.UnchangedObjects)
+	logRaw("Materials: %d added, %d removed, %d modified, %d unchanged", counts["m"+diffAdded], counts["m"+diffRemoved], counts["m"+diffModified], r.UnchangedMaterials)
+}
+
+// eg. "o Wheel [rubber] 120 faces, 64 vertices".
+func (o *diffObject) String() string {
+	return fmt.Sprintf("%s %s [%s] %d faces, %d lines, %d points, %d vertices", o.Type, o.Name, materialLabel(o.Material), o.Faces, o.Lines, o.Points, o.Vertices)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// generate command: writes a synthetic OBJ for benchmarking and load
+// testing, eg. to reproduce memory use on huge inputs without shipping them.
+
+func runGenerate(cmd *command, args []string) int {
+	fs := cmd.FlagSet()
+	options := GenerateOptions{
+		Vertices:   10000,
+		Duplicates: 0.2,
+		Noise:      1e-7,
+		Objects:    10,
+		Materials:  1,
+		Seed:       1,
+	}
+	registerOptions(fs, &options)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	// "-" writes the model to stdout, log to stderr
+	initLogging(path == "-")
+
+	if err := options.Validate(); err != nil {
+		logRaw("%s", err)
+		return 2
+	}
+
+	var (
+		started = time.Now()
+		lines   int
+		err     error
+	)
+	if path == "-" {
+		lines, err = generateOBJ(os.Stdout, options)
+	} else {
+		lines, err = writeFile(path, func(w io.Writer) (int, error) {
+			return generateOBJ(w, options)
+		})
+	}
+	if err != nil {
+		logRaw("%s", err)
+		return 1
+	}
+	if path == "-" {
+		logInfo("%s lines written in %s", formatInt(lines), formatDurationSince(started))
+	} else {
+		logInfo("%s: %s lines, %s written in %s", path, formatInt(lines), formatBytes(fileSize(path)), formatDurationSince(started))
+	}
+	return 0
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// serve command: simplifies models POSTed over HTTP.
+
+// Flags that read or write local files or control the process, not settable with query parameters.
+var serveDeniedParams = map[string]bool{
+	"in": true, "out": true, "stdout": true, "report": true, "config": true, "plugin": true, "analyze": true, "json-bin": true,
+	"watch": true, "watch-interval": true, "watch-debounce": true,
+	"workers": true, "timeout": true, "quiet": true, "no-progress": true, "progress": true, "cpu-profile": true, "version": true,
+}
+
+func runServe(cmd *command, args []string) int {
+	fs := cmd.FlagSet()
+	addr := fs.String("addr", ":8080", "Address to listen on.")
+	maxSize := fs.Int64("max-size", 256, "Maximum request body size in megabytes.")
+	concurrency := fs.Int("concurrency", 4, "Maximum number of requests being processed or waiting to be processed, others are rejected with 503. Requests are processed one at a time, this is the queue length and not parallelism.")
+	fs.DurationVar(&StartParams.Timeout, "timeout", 5*time.Minute, "Abort processing a request if it takes longer than this. <=0 disables.")
+	fs.Parse(args)
+
+	initLogging(false)
+
+	if *maxSize < 1 || *concurrency < 1 {
+		logRaw("-max-size and -concurrency must be positive")
+		return 2
+	}
+
+	logRaw("%s %s listening on %s", ApplicationName, getVersion(false), *addr)
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(*maxSize<<20, *concurrency),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		logRaw("%s", err)
+		return 1
+	}
+	return 0
+}
+
+// server is the HTTP handler of the serve command. The processors and writers
+// are configured with the global StartParams so requests are processed one at a time.
+type server struct {
+	maxBytes int64
+	// limits requests that are being processed or waiting for mu
+	slots chan struct{}
+	mu    sync.Mutex
+}
+
+func newServer(maxBytes int64, concurrency int) *server {
+	return &server{
+		maxBytes: maxBytes,
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+// httpError is returned by the request handling to respond with status.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func newHTTPError(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST the model file, see the README for the query parameters", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "too many requests in progress", http.StatusServiceUnavailable)
+		return
+	}
+
+	start := time.Now()
+	err := s.handle(w, r)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		var (
+			hErr     *httpError
+			errBytes *http.MaxBytesError
+		)
+		if errors.As(err, &hErr) {
+			status = hErr.status
+		} else if errors.As(err, &errBytes) || errors.Is(err, errInputTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		logRaw("%s %s %s | %d %s", start.Format("15:04:05"), r.Method, r.URL.RequestURI(), status, err)
+		return
+	}
+	logRaw("%s %s %s | 200 | %s", start.Format("15:04:05"), r.Method, r.URL.RequestURI(), formatDuration(time.Since(start)))
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) error {
+	dir, err := os.MkdirTemp("", ApplicationName+"-")
+	if err != nil {
+		return newHTTPError(http.StatusInternalServerError, "%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBytes)
+	input, err := receiveFiles(r, dir)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.run(w, r, input, filepath.Join(dir, "out"))
+}
+
+// Stores the request body to dir. The body is either the model file, its name
+// can be given with ?name=, or multipart/form-data with the model and .mtl files.
+// Returns the path of the model file.
+func receiveFiles(r *http.Request, dir string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		name := r.URL.Query().Get("name")
+		if len(name) == 0 {
+			name = "model.obj"
+		}
+		path, err := receiveFile(dir, name, r.Body)
+		if err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", newHTTPError(http.StatusBadRequest, "%s", err)
+	}
+	model := ""
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if len(part.FileName()) == 0 {
+			continue
+		}
+		path, err := receiveFile(dir, part.FileName(), part)
+		if err != nil {
+			return "", err
+		}
+		if isModelExtension(fileExtension(trimCompressionExtension(path))) {
+			if len(model) > 0 {
+				return "", newHTTPError(http.StatusBadRequest, "multiple model files: %s and %s", filepath.Base(model), part.FileName())
+			}
+			model = path
+		}
+	}
+	if len(model) == 0 {
+		return "", newHTTPError(http.StatusBadRequest, "no .obj, .stl or .ply file in the form data")
+	}
+	return model, nil
+}
+
+func receiveFile(dir, name string, r io.Reader) (string, error) {
+	name = filepath.Base(filepath.FromSlash(name))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", newHTTPError(http.StatusBadRequest, "invalid file name %q", name)
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", newHTTPError(http.StatusInternalServerError, "%s", err)
+	}
+	_, err = io.Copy(f, r)
+	if cErr := f.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	return path, err
+}
+
+// Runs the pipeline for input with the query parameters applied on top of the
+// defaults and writes the response. StartParams and the processors are restored afterwards.
+func (s *server) run(w http.ResponseWriter, r *http.Request, input, outDir string) error {
+	var (
+		params   = StartParams
+		pipeline = Pipeline
+		disabled = make([]bool, len(Processors))
+		restore  = make([]func(), 0, len(Processors))
+	)
+	for i, processor := range Processors {
+		disabled[i] = processor.Disabled
+		if cp, ok := processor.Processor.(ContextProcessor); ok {
+			restore = append(restore, saveOptions(cp.Options()))
+		}
+	}
+	defer func() {
+		StartParams = params
+		Pipeline = pipeline
+		for i, processor := range Processors {
+			processor.Disabled = disabled[i]
+		}
+		for _, fn := range restore {
+			fn()
+		}
+	}()
+
+	if err := applyQueryParams(r); err != nil {
+		return newHTTPError(http.StatusBadRequest, "%s", err)
+	}
+	if err := checkOptions(); err != nil {
+		return newHTTPError(http.StatusBadRequest, "%s", err)
+	}
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		return newHTTPError(http.StatusInternalServerError, "%s", err)
+	}
+	StartParams.Input = input
+	StartParams.Output = filepath.Join(outDir, filepath.Base(defaultOutputPath(input)))
+	StartParams.Stdout, StartParams.Report, StartParams.JsonBin = false, "", false
+	StartParams.Quiet, StartParams.NoProgress = true, true
+	// compressed uploads are limited when decompressed as well
+	maxInputSize, confineMtllibs = s.maxBytes, true
+	defer func() { maxInputSize, confineMtllibs = 0, false }()
+
+	res, err := runPipeline(r.Context())
+	if err != nil {
+		return err
+	}
+	return writeServeResponse(w, r, res, Report)
+}
+
+// Sets StartParams and the processor toggles from query parameters named like the flags
+// eg. "?format=ply&epsilon=0.001&no-merge". Empty bool values are true. The parameters
+// override the options of ?preset=.
+func applyQueryParams(r *http.Request) error {
+	explicit := make(map[string]bool)
+	for name, values := range r.URL.Query() {
+		explicit[name] = true
+		if name == "name" {
+			continue
+		}
+		f := flag.Lookup(name)
+		if f == nil || serveDeniedParams[name] {
+			return fmt.Errorf("unsupported parameter %q", name)
+		}
+		value := values[len(values)-1]
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() && len(value) == 0 {
+			value = "true"
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %s", value, name, err)
+		}
+	}
+	if len(StartParams.Preset) > 0 {
+		return applyConfig("", StartParams.Preset, explicit)
+	}
+	return nil
+}
+
+// Responds with the output file. Stats are set to X-Simplify-* headers. A multipart/mixed
+// response with the JSON report as the first part is written if the client accepts it,
+// or if the output format writes multiple files.
+func writeServeResponse(w http.ResponseWriter, r *http.Request, res *runResult, report *runReport) error {
+	h := w.Header()
+	h.Set("X-Simplify-Before", formatServeStats(res.Pre))
+	h.Set("X-Simplify-After", formatServeStats(res.Post))
+	h.Set("X-Simplify-Duration", fmt.Sprintf("%.3f", res.Duration.Seconds()))
+	h.Set("X-Simplify-Warnings", intToString(len(report.Warnings)))
+
+	if len(res.OutputFiles) == 1 && !strings.Contains(r.Header.Get("Accept"), "multipart/mixed") {
+		setServeFileHeaders(textproto.MIMEHeader(h), res.OutputFiles[0])
+		return copyFile(w, res.OutputFiles[0])
+	}
+
+	report.Files = reportFiles{
+		Input:       filepath.Base(res.Input),
+		Output:      filepath.Base(res.Output),
+		InputBytes:  res.SizeIn,
+		OutputBytes: res.SizeOut,
+		LinesInput:  res.LinesParsed,
+		LinesOutput: res.LinesWritten,
+	}
+	report.Params.Input, report.Params.Output = filepath.Base(res.Input), filepath.Base(res.Output)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	mw := multipart.NewWriter(w)
+	h.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/json"},
+		"Content-Disposition": {`attachment; filename="report.json"`},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	for _, path := range res.OutputFiles {
+		header := textproto.MIMEHeader{}
+		setServeFileHeaders(header, path)
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(part, path); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// Content types of the output formats, system mime tables often map
+// .obj to unrelated types.
+var serveContentTypes = map[string]string{
+	".obj":  "model/obj",
+	".stl":  "model/stl",
+	".ply":  "application/octet-stream",
+	".off":  "text/plain; charset=utf-8",
+	".usda": "text/plain; charset=utf-8",
+	".x3d":  "model/x3d+xml",
+	".dae":  "model/vnd.collada+xml",
+	".json": "application/json",
+	".bin":  "application/octet-stream",
+}
+
+// Gzip output is sent with Content-Encoding so that clients decompress it transparently.
+func setServeFileHeaders(h textproto.MIMEHeader, path string) {
+	name := filepath.Base(trimCompressionExtension(path))
+	contentType, ok := serveContentTypes[fileExtension(name)]
+	if !ok {
+		contentType = mime.TypeByExtension(fileExtension(name))
+	}
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if StartParams.IsGzipEnabled() {
+		h.Set("Content-Encoding", "gzip")
+	}
+}
+
+func formatServeStats(stats objectfile.ObjStats) string {
+	return fmt.Sprintf("objects=%d, groups=%d, faces=%d, lines=%d, points=%d, vertices=%d, normals=%d, uvs=%d",
+		stats.Objects, stats.Groups, stats.Faces, stats.Lines, stats.Points,
+		stats.Geometry.Vertices, stats.Geometry.Normals, stats.Geometry.UVs)
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+const serveTestOBJ = `mtllib model.mtl
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 1 1 0
+o a
+usemtl red
+f 1 2 3
+o b
+usemtl red
+f 1 2 4
+`
+
+// Runs the request against s without logging it.
+func serveForTest(s *server, r *http.Request) *httptest.ResponseRecorder {
+	defer func(w io.Writer) { logwriter = w }(logwriter)
+	logwriter = io.Discard
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeMultipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range map[string]string{"model.obj": serveTestOBJ, "model.mtl": "newmtl red\nKd 1 0 0\n"} {
+		part, err := mw.CreateFormFile(name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/?compact", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	w := serveForTest(newServer(1<<20, 1), r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if after := w.Header().Get("X-Simplify-After"); !strings.Contains(after, "objects=1,") || !strings.Contains(after, "vertices=3,") {
+		t.Errorf("X-Simplify-After: %s", after)
+	}
+	if out := w.Body.String(); !strings.Contains(out, "usemtl red\n") || strings.Count(out, "\nf ") != 2 {
+		t.Errorf("output:\n%s", out)
+	}
+	if w.Header().Get("X-Simplify-Warnings") != "0" {
+		t.Errorf("%s warnings, model.mtl was not found", w.Header().Get("X-Simplify-Warnings"))
+	}
+}
+
+func TestServeGzipResponse(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/?gzip=6", strings.NewReader(serveTestOBJ))
+	w := serveForTest(newServer(1<<20, 1), r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Content-Encoding %q", enc)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parse(bytes.NewReader(out), "model.obj"); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+}
+
+func TestServeTooLarge(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("v 0 0 0\n", 1000)))
+	if w := serveForTest(newServer(1000, 1), r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %s", w.Code, w.Body)
+	}
+
+	// small zip that is large decompressed
+	var body bytes.Buffer
+	zw := zip.NewWriter(&body)
+	for _, name := range []string{"model.mtl", "model.obj"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, strings.Repeat("# padding\n", 100000))
+	}
+	zw.Close()
+	if body.Len() > 64*1024 {
+		t.Fatalf("zip is %d bytes", body.Len())
+	}
+	r = httptest.NewRequest(http.MethodPost, "/?name=model.zip", &body)
+	if w := serveForTest(newServer(64*1024, 1), r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("zip status %d, want 413: %s", w.Code, w.Body)
+	}
+}
+
+func TestServeQueueFull(t *testing.T) {
+	s := newServer(1<<20, 1)
+	// a request is being processed
+	s.slots <- struct{}{}
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(serveTestOBJ))
+	w := serveForTest(s, r)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status %d, want 503 with Retry-After", w.Code)
+	}
+	<-s.slots
+	if w := serveForTest(s, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(serveTestOBJ))); w.Code != http.StatusOK {
+		t.Fatalf("status %d after the queue emptied: %s", w.Code, w.Body)
+	}
+}
+
+// Query parameters apply to the request only, the processor options are restored afterwards.
+func TestServeRestoresOptions(t *testing.T) {
+	epsilon := geometryTolerances(nil)[objectfile.Vertex].Epsilon
+	r := httptest.NewRequest(http.MethodPost, "/?epsilon=0.5", strings.NewReader(serveTestOBJ))
+	if w := serveForTest(newServer(1<<20, 1), r); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if got := geometryTolerances(nil)[objectfile.Vertex].Epsilon; got != epsilon {
+		t.Fatalf("-epsilon is %g after the request, want %g", got, epsilon)
+	}
+}
+
+// mtllib paths can't read files outside the request directory.
+func TestServeMtllibTraversal(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret.mtl")
+	if err := os.WriteFile(secret, []byte("newmtl red\nKd 0.125 0.25 0.375\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	model := strings.Replace(serveTestOBJ, "mtllib model.mtl", "mtllib "+strings.Repeat("../", 32)+filepath.ToSlash(secret), 1)
+	r := httptest.NewRequest(http.MethodPost, "/?format=usda", strings.NewReader(model))
+	w := serveForTest(newServer(1<<20, 1), r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if out := w.Body.String(); strings.Contains(out, "0.125") {
+		t.Fatalf("output contains the material of %s:\n%s", secret, out)
+	}
+}
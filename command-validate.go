@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// validate command: reports spec violations and things that commonly
+// break rendering engines without modifying the file.
+
+type validationSeverity int
+
+const (
+	severityWarning validationSeverity = iota
+	severityError
+)
+
+func (s validationSeverity) String() string {
+	if s == severityError {
+		return "error"
+	}
+	return "warning"
+}
+
+type validationIssue struct {
+	File     string
+	Line     int
+	Severity validationSeverity
+	Message  string
+}
+
+func (issue *validationIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", issue.File, issue.Line, issue.Severity, issue.Message)
+}
+
+// Statements that are valid in the spec but not supported by this tool or most engines.
+var unsupportedStatements = []string{
+	"cstype", "deg", "bmat", "step", "curv", "curv2", "surf", "parm", "trim", "hole", "scrv", "sp", "end",
+	"con", "mg", "bevel", "c_interp", "d_interp", "lod", "shadow_obj", "trace_obj", "ctech", "stech", "call", "csh",
+}
+
+func runValidate(cmd *command, args []string) int {
+	fs := cmd.FlagSet()
+	strict := fs.Bool("strict", false, "Treat warnings as errors.")
+	fs.Parse(args)
+
+	initLogging(false)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	exitCode := 0
+	for _, path := range fs.Args() {
+		v := newValidator(path)
+		if err := v.Validate(); err != nil {
+			logRaw("%s: %s", path, err)
+			exitCode = 1
+			continue
+		}
+		errors, warnings := 0, 0
+		for _, issue := range v.issues {
+			logRaw(issue.String())
+			if issue.Severity == severityError {
+				errors++
+			} else {
+				warnings++
+			}
+		}
+		logRaw("%s: %d errors, %d warnings", path, errors, warnings)
+		if errors > 0 || (*strict && warnings > 0) {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+type validator struct {
+	path   string
+	issues []*validationIssue
+
+	// declared geometry so far
+	vertices, uvs, params int
+	normals               []objectfile.Vector
+	zeroNormalsReported   map[int]bool
+
+	// mtllib files and the usemtl statements to check against them
+	materials     map[string]*objectfile.Material
+	mtllibs       int
+	usedMaterials []*objectfile.MaterialProperty
+
+	// face format of the current object, eg. "v/vt/vn"
+	object           string
+	objectFormat     string
+	objectFormatLine int
+
+	// vertex index edges: undirected use count and directed use count
+	edges         map[[2]int]int
+	directedEdges map[[2]int]int
+}
+
+func newValidator(path string) *validator {
+	return &validator{
+		path:                path,
+		zeroNormalsReported: make(map[int]bool),
+		materials:           make(map[string]*objectfile.Material),
+		edges:               make(map[[2]int]int),
+		directedEdges:       make(map[[2]int]int),
+	}
+}
+
+func (v *validator) report(file string, line int, severity validationSeverity, format string, args ...interface{}) {
+	v.issues = append(v.issues, &validationIssue{
+		File:     file,
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) errorf(line int, format string, args ...interface{}) {
+	v.report(v.path, line, severityError, format, args...)
+}
+
+func (v *validator) warnf(line int, format string, args ...interface{}) {
+	v.report(v.path, line, severityWarning, format, args...)
+}
+
+func (v *validator) Validate() error {
+	f, err := os.Open(v.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	linenum := 0
+	for scanner.Scan() {
+		linenum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			key, value = line[0:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch t := objectfile.TypeFromString(key); t {
+		case objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param:
+			v.validateGeometry(linenum, t, value)
+		case objectfile.Face, objectfile.Line, objectfile.Point:
+			v.validateVertexData(linenum, t, value)
+		case objectfile.ChildObject, objectfile.ChildGroup:
+			v.object = value
+			v.objectFormat = ""
+		case objectfile.MtlLib:
+			v.loadMtllib(linenum, value)
+		case objectfile.MtlUse:
+			v.usedMaterials = append(v.usedMaterials, &objectfile.MaterialProperty{Key: key, Value: value, Line: linenum})
+		case objectfile.SmoothingGroup:
+		default:
+			unsupported := false
+			for _, statement := range unsupportedStatements {
+				if key == statement {
+					unsupported = true
+					break
+				}
+			}
+			if unsupported {
+				v.warnf(linenum, "%q is valid in the spec but not supported by %s or most engines", key, ApplicationName)
+			} else {
+				v.errorf(linenum, "unknown statement %q", key)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// usemtl can be declared before mtllib
+	if v.mtllibs > 0 {
+		for _, used := range v.usedMaterials {
+			if v.materials[used.Value] == nil {
+				v.errorf(used.Line, "usemtl %q is not declared in any mtllib", used.Value)
+			}
+		}
+	} else if len(v.usedMaterials) > 0 {
+		v.warnf(v.usedMaterials[0].Line, "usemtl used but no mtllib declared")
+	}
+
+	// issues from mtl files are reported after the obj issues
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		if v.issues[i].File != v.issues[j].File {
+			return v.issues[i].File == v.path
+		}
+		return v.issues[i].Line < v.issues[j].Line
+	})
+	return nil
+}
+
+func (v *validator) validateGeometry(linenum int, t objectfile.Type, value string) {
+	parts := strings.Fields(value)
+	min, max := 3, 3
+	switch t {
+	case objectfile.Vertex:
+		// 6 and 7 are the common "v x y z r g b [a]" vertex color extension
+		min, max = 3, 7
+	case objectfile.UV, objectfile.Param:
+		min = 1
+	}
+	if len(parts) < min || len(parts) > max {
+		v.errorf(linenum, "%s declares %d components, expected %d to %d", t, len(parts), min, max)
+	}
+
+	var vec objectfile.Vector
+	for i, part := range parts {
+		num, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			v.errorf(linenum, "%s component %d is not a number: %q", t, i+1, part)
+			continue
+		}
+		if math.IsNaN(num) || math.IsInf(num, 0) {
+			v.errorf(linenum, "%s component %d is %s", t, i+1, part)
+		}
+		switch i {
+		case 0:
+			vec.X = num
+		case 1:
+			vec.Y = num
+		case 2:
+			vec.Z = num
+		}
+	}
+
+	switch t {
+	case objectfile.Vertex:
+		v.vertices++
+	case objectfile.Normal:
+		v.normals = append(v.normals, vec)
+	case objectfile.UV:
+		v.uvs++
+	case objectfile.Param:
+		v.params++
+	}
+}
+
+// Returns the absolute index, or 0 if index is invalid.
+func (v *validator) resolveIndex(linenum int, t objectfile.Type, index, declared int) int {
+	if index == 0 {
+		v.errorf(linenum, "%s index 0 is invalid, indexes start from 1", t)
+		return 0
+	}
+	abs := index
+	if index < 0 {
+		abs = declared + index + 1
+	}
+	if abs < 1 || abs > declared {
+		v.errorf(linenum, "%s index %d out of bounds, %d declared so far", t, index, declared)
+		return 0
+	}
+	return abs
+}
+
+func (v *validator) validateVertexData(linenum int, t objectfile.Type, value string) {
+	var (
+		corners  = strings.Fields(value)
+		format   = ""
+		vertices = make([]int, 0, len(corners))
+	)
+	for _, corner := range corners {
+		parts := strings.Split(corner, "/")
+		if len(parts) > 3 || (t != objectfile.Face && len(parts) > 2) {
+			v.errorf(linenum, "invalid %s corner %q", t, corner)
+			return
+		}
+		cornerFormat := "v"
+		for i, part := range parts {
+			if i > 0 && len(part) == 0 {
+				cornerFormat += "/"
+				continue
+			}
+			index, err := strconv.Atoi(part)
+			if err != nil {
+				v.errorf(linenum, "invalid %s index %q in %q", t, part, corner)
+				return
+			}
+			switch i {
+			case 0:
+				vertices = append(vertices, v.resolveIndex(linenum, objectfile.Vertex, index, v.vertices))
+			case 1:
+				cornerFormat += "/vt"
+				v.resolveIndex(linenum, objectfile.UV, index, v.uvs)
+			case 2:
+				cornerFormat += "/vn"
+				if abs := v.resolveIndex(linenum, objectfile.Normal, index, len(v.normals)); abs > 0 {
+					if v.normals[abs-1].Length() < 1e-12 && !v.zeroNormalsReported[abs] {
+						v.zeroNormalsReported[abs] = true
+						v.warnf(linenum, "references normal %d that has zero length", abs)
+					}
+				}
+			}
+		}
+		cornerFormat = strings.TrimRight(cornerFormat, "/")
+		if len(format) == 0 {
+			format = cornerFormat
+		} else if format != cornerFormat {
+			v.errorf(linenum, "%s mixes corner formats %s and %s", t, format, cornerFormat)
+			return
+		}
+	}
+
+	switch t {
+	case objectfile.Face:
+		if len(corners) < 3 {
+			v.errorf(linenum, "face declares %d vertices, at least 3 required", len(corners))
+			return
+		}
+		if len(v.objectFormat) == 0 {
+			v.objectFormat, v.objectFormatLine = format, linenum
+		} else if v.objectFormat != format {
+			v.warnf(linenum, "face format %s differs from %s used by object %q on line %d", format, v.objectFormat, v.object, v.objectFormatLine)
+		}
+		v.validateEdges(linenum, vertices)
+	case objectfile.Line:
+		if len(corners) < 2 {
+			v.errorf(linenum, "line declares %d vertices, at least 2 required", len(corners))
+		}
+	case objectfile.Point:
+		if len(corners) < 1 {
+			v.errorf(linenum, "point declares no vertices")
+		}
+	}
+}
+
+func (v *validator) validateEdges(linenum int, vertices []int) {
+	for i := range vertices {
+		a, b := vertices[i], vertices[(i+1)%len(vertices)]
+		if a == 0 || b == 0 || a == b {
+			continue
+		}
+		undirected := [2]int{a, b}
+		if a > b {
+			undirected = [2]int{b, a}
+		}
+		v.edges[undirected]++
+		v.directedEdges[[2]int{a, b}]++
+
+		// report each edge once, when it first becomes invalid
+		if v.edges[undirected] == 3 {
+			v.warnf(linenum, "non-manifold edge %d-%d is shared by more than 2 faces", a, b)
+		} else if v.edges[undirected] == 2 && v.directedEdges[[2]int{a, b}] == 2 {
+			v.warnf(linenum, "inconsistent winding, edge %d-%d is traversed in the same direction by 2 faces", a, b)
+		}
+	}
+}
+
+func (v *validator) loadMtllib(linenum int, value string) {
+	for _, name := range strings.Fields(value) {
+		path := filepath.Join(filepath.Dir(v.path), name)
+		if !fileExists(path) {
+			v.errorf(linenum, "mtllib %q not found", name)
+			continue
+		}
+		materials, err := ParseMtlFile(path)
+		if err != nil {
+			v.report(path, 0, severityError, "%s", err)
+			continue
+		}
+		v.mtllibs++
+		for _, material := range materials {
+			if existing := v.materials[material.Name]; existing != nil {
+				v.report(path, material.Line, severityWarning, "material %q already declared in %s:%d", material.Name, existing.Mtllib, existing.Line)
+				continue
+			}
+			v.materials[material.Name] = material
+			for _, texture := range material.TextureProperties() {
+				texturePath := filepath.FromSlash(strings.Replace(texture.TexturePath(), "\\", "/", -1))
+				if !filepath.IsAbs(texturePath) {
+					texturePath = filepath.Join(filepath.Dir(path), texturePath)
+				}
+				if !fileExists(texturePath) {
+					v.report(path, texture.Line, severityWarning, "%s texture %q not found", texture.Key, texture.TexturePath())
+				}
+			}
+		}
+	}
+}
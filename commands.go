@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Commands are run with "obj-simplify <command> [flags]" instead
+// of the default simplification run.
+var (
+	Commands = []*command{
+		&command{Name: "validate", Usage: "[flags] <file> [file ...]", Desc: "Validates files against the OBJ spec and common engine expectations.", Run: runValidate},
+		&command{Name: "compare", Usage: "[flags] <a> <b>", Desc: "Measures the surface, UV and normal deviation between two models.", Run: runCompare},
+		&command{Name: "diff", Usage: "[flags] <a> <b>", Desc: "Reports added, removed and modified objects and materials between two models.", Run: runDiff},
+		&command{Name: "generate", Usage: "[flags] <output|->", Desc: "Writes a synthetic OBJ for benchmarking and load testing.", Run: runGenerate},
+		&command{Name: "serve", Usage: "[flags]", Desc: "Runs an HTTP server that simplifies POSTed models.", Run: runServe},
+	}
+)
+
+type command struct {
+	Name  string
+	Usage string
+	Desc  string
+
+	// Receives the arguments following the command name, returns the exit code.
+	Run func(cmd *command, args []string) int
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range Commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Creates a flag set for cmd with usage that matches the main usage.
+func (cmd *command) FlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage:\n  %s %s %s\n\nFlags:\n", cmd.Desc, ApplicationName, cmd.Name, cmd.Usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s [flags]\n  %s <command> [flags]\n\nCommands:\n", ApplicationName, ApplicationName)
+	for _, cmd := range Commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.Name, cmd.Desc)
+	}
+	fmt.Fprintf(os.Stderr, "\nOutput formats:\n")
+	for _, enc := range Encoders {
+		fmt.Fprintf(os.Stderr, "  %-12s %-12s %s\n", enc.Name(), strings.Join(enc.Extensions(), " "), enc.Desc())
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Geometric comparison of two models for -verify and the compare command.
+// The faces of both models are sampled and every sample is matched to the
+// closest point on the faces of the other model that use the same material.
+// Lines and points are not compared.
+
+// VerifyOptions are the sampling and thresholds of -verify and the compare command.
+type VerifyOptions struct {
+	VerifySamples        int     `flag:"verify-samples" usage:"Number of random surface samples per model for -verify and compare, in addition to the face corners."`
+	VerifyMaxDistance    float64 `flag:"verify-max-distance" usage:"Maximum symmetric Hausdorff distance relative to the bounding box diagonal of the first model eg. 0.001 for 0.1%. <0 disables."`
+	VerifyMaxRMS         float64 `flag:"verify-max-rms" usage:"Maximum RMS distance relative to the bounding box diagonal of the first model. <0 disables."`
+	VerifyMaxUV          float64 `flag:"verify-max-uv" usage:"Maximum UV deviation in texture coordinate units. <0 disables."`
+	VerifyMaxNormalAngle float64 `flag:"verify-max-normal-angle" usage:"Maximum normal deviation in degrees. <0 disables."`
+}
+
+func (o *VerifyOptions) Validate() error {
+	if o.VerifySamples < 0 {
+		return fmt.Errorf("-verify-samples must not be negative, given: %d", o.VerifySamples)
+	}
+	return nil
+}
+
+// CompareResult is the difference of two models. Distances are in model units.
+type CompareResult struct {
+	// Bounding box diagonal of the first model, relative thresholds are multiplied by it.
+	Diagonal       float64                  `json:"diagonal"`
+	Samples        int                      `json:"samples"`
+	Hausdorff      float64                  `json:"hausdorff"`
+	RMS            float64                  `json:"rms"`
+	UVMax          float64                  `json:"uv_max"`
+	NormalAngleMax float64                  `json:"normal_angle_max"`
+	Materials      []*CompareMaterialResult `json:"materials"`
+	// Exceeded thresholds, the comparison fails if not empty.
+	Failures []string `json:"failures"`
+}
+
+type CompareMaterialResult struct {
+	Material        string  `json:"material"`
+	Samples         int     `json:"samples"`
+	Hausdorff       float64 `json:"hausdorff"`
+	RMS             float64 `json:"rms"`
+	UVMax           float64 `json:"uv_max"`
+	UVRMS           float64 `json:"uv_rms"`
+	NormalAngleMax  float64 `json:"normal_angle_max"`
+	NormalAngleMean float64 `json:"normal_angle_mean"`
+	// Name of the model that has no faces with the material.
+	Missing string `json:"missing,omitempty"`
+}
+
+// surface is the triangulated faces of a model by material. Values are copied
+// so that processors modifying the model afterwards do not change it.
+type surface struct {
+	Materials []string
+	Triangles map[string][]surfaceTriangle
+	Box       objectfile.BoundingBox
+}
+
+type surfaceTriangle struct {
+	P, UV, N         [3]objectfile.Vector
+	HasUV, HasNormal bool
+}
+
+// A point on a triangle as barycentric weights of its corners.
+type surfaceSample struct {
+	tri  *surfaceTriangle
+	bary [3]float64
+}
+
+// Faces thinner than a millionth of the bounding box diagonal are not visible and
+// are skipped, their UVs and normals are often garbage.
+func newSurface(obj *objectfile.OBJ) *surface {
+	m := buildMesh(obj)
+	s := &surface{
+		Triangles: make(map[string][]surfaceTriangle),
+		Box:       objectfile.NewBoundingBox(),
+	}
+	for _, position := range m.Positions {
+		s.Box.Expand(position.Vector())
+	}
+	minHeight := s.Box.Diagonal() * 1e-6
+	for _, face := range m.Faces {
+		material := m.Materials[face.Material]
+		for _, corners := range face.Triangles() {
+			t := surfaceTriangle{HasUV: true, HasNormal: true}
+			for i, index := range corners {
+				t.P[i] = meshValue(m.Positions[index])
+				t.UV[i] = meshValue(m.UVs[index])
+				t.N[i] = meshValue(m.Normals[index])
+				t.HasUV = t.HasUV && m.UVs[index] != nil
+				t.HasNormal = t.HasNormal && m.Normals[index] != nil
+			}
+			if t.Height() <= minHeight {
+				continue
+			}
+			if _, found := s.Triangles[material]; !found {
+				s.Materials = append(s.Materials, material)
+			}
+			s.Triangles[material] = append(s.Triangles[material], t)
+		}
+	}
+	return s
+}
+
+func (s *surface) Area(material string) (area float64) {
+	for i := range s.Triangles[material] {
+		area += s.Triangles[material][i].Area()
+	}
+	return area
+}
+
+func (t *surfaceTriangle) Area() float64 {
+	return t.P[1].Sub(t.P[0]).Cross(t.P[2].Sub(t.P[0])).Length() * 0.5
+}
+
+// Returns the smallest height of the triangle.
+func (t *surfaceTriangle) Height() float64 {
+	longest := 0.0
+	for i := 0; i < 3; i++ {
+		longest = math.Max(longest, t.P[(i+1)%3].Sub(t.P[i]).Length())
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 2 * t.Area() / longest
+}
+
+func (t *surfaceTriangle) Centroid() objectfile.Vector {
+	return t.P[0].Add(t.P[1]).Add(t.P[2]).Scale(1.0 / 3.0)
+}
+
+func interpolate(values [3]objectfile.Vector, bary [3]float64) objectfile.Vector {
+	return values[0].Scale(bary[0]).Add(values[1].Scale(bary[1])).Add(values[2].Scale(bary[2]))
+}
+
+// Returns the unique corners of the triangles and samples random points
+// distributed by area. The same triangles always produce the same samples.
+func sampleTriangles(triangles []surfaceTriangle, samples int) []surfaceSample {
+	corners := make(map[[3]objectfile.Vector]bool)
+	result := make([]surfaceSample, 0, len(triangles)+samples)
+	cumulative := make([]float64, len(triangles))
+	total := 0.0
+	for ti := range triangles {
+		t := &triangles[ti]
+		for i := 0; i < 3; i++ {
+			key := [3]objectfile.Vector{t.P[i], t.UV[i], t.N[i]}
+			if !corners[key] {
+				corners[key] = true
+				bary := [3]float64{}
+				bary[i] = 1
+				result = append(result, surfaceSample{tri: t, bary: bary})
+			}
+		}
+		total += t.Area()
+		cumulative[ti] = total
+	}
+	if total <= 0 {
+		return result
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < samples; i++ {
+		ti := sort.SearchFloat64s(cumulative, r.Float64()*total)
+		if ti >= len(triangles) {
+			ti = len(triangles) - 1
+		}
+		s := math.Sqrt(r.Float64())
+		r2 := r.Float64()
+		result = append(result, surfaceSample{tri: &triangles[ti], bary: [3]float64{1 - s, s * (1 - r2), s * r2}})
+	}
+	return result
+}
+
+// triangleTree is a bounding volume hierarchy for closest point queries.
+type triangleTree struct {
+	triangles []surfaceTriangle
+	nodes     []triangleNode
+}
+
+type triangleNode struct {
+	box objectfile.BoundingBox
+	// child node indexes of inner nodes, -1 for leaves
+	left, right int
+	// triangles of leaves
+	start, end int
+}
+
+type triangleHit struct {
+	tri      *surfaceTriangle
+	bary     [3]float64
+	distance float64
+}
+
+func newTriangleTree(triangles []surfaceTriangle) *triangleTree {
+	t := &triangleTree{triangles: append([]surfaceTriangle(nil), triangles...)}
+	if len(t.triangles) > 0 {
+		t.build(0, len(t.triangles))
+	}
+	return t
+}
+
+// Splits the triangles at the median centroid of the longest axis.
+func (t *triangleTree) build(start, end int) int {
+	node := triangleNode{box: objectfile.NewBoundingBox(), left: -1, right: -1, start: start, end: end}
+	centroids := objectfile.NewBoundingBox()
+	for i := start; i < end; i++ {
+		for _, p := range t.triangles[i].P {
+			node.box.Expand(p)
+		}
+		centroids.Expand(t.triangles[i].Centroid())
+	}
+	index := len(t.nodes)
+	t.nodes = append(t.nodes, node)
+	if end-start <= 4 {
+		return index
+	}
+	size := centroids.Size()
+	axis := func(v objectfile.Vector) float64 { return v.X }
+	if size.Y > size.X && size.Y >= size.Z {
+		axis = func(v objectfile.Vector) float64 { return v.Y }
+	} else if size.Z > size.X && size.Z > size.Y {
+		axis = func(v objectfile.Vector) float64 { return v.Z }
+	}
+	triangles := t.triangles[start:end]
+	sort.Slice(triangles, func(i, j int) bool {
+		return axis(triangles[i].Centroid()) < axis(triangles[j].Centroid())
+	})
+	mid := start + (end-start)/2
+	left := t.build(start, mid)
+	right := t.build(mid, end)
+	t.nodes[index].left, t.nodes[index].right = left, right
+	return index
+}
+
+// Returns the distance to the closest point on the triangles and the triangles that are
+// within tolerance of it.
+func (t *triangleTree) Closest(p objectfile.Vector, tolerance float64, hits []triangleHit) (float64, []triangleHit) {
+	hits = hits[:0]
+	best := math.Inf(1)
+	if len(t.nodes) == 0 {
+		return best, hits
+	}
+	stack := make([]int, 1, 64)
+	for len(stack) > 0 {
+		node := &t.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if boxDistance(node.box, p) > best+tolerance {
+			continue
+		}
+		if node.left == -1 {
+			for i := node.start; i < node.end; i++ {
+				q, bary := closestPointOnTriangle(p, t.triangles[i].P)
+				d := q.Sub(p).Length()
+				if !(d <= best+tolerance) {
+					continue
+				}
+				if d < best {
+					best = d
+					kept := hits[:0]
+					for _, hit := range hits {
+						if hit.distance <= best+tolerance {
+							kept = append(kept, hit)
+						}
+					}
+					hits = kept
+				}
+				hits = append(hits, triangleHit{tri: &t.triangles[i], bary: bary, distance: d})
+			}
+			continue
+		}
+		// visit the nearer child first
+		near, far := node.left, node.right
+		if boxDistance(t.nodes[far].box, p) < boxDistance(t.nodes[near].box, p) {
+			near, far = far, near
+		}
+		stack = append(stack, far, near)
+	}
+	return best, hits
+}
+
+func boxDistance(box objectfile.BoundingBox, p objectfile.Vector) float64 {
+	dx := math.Max(math.Max(box.Min.X-p.X, 0), p.X-box.Max.X)
+	dy := math.Max(math.Max(box.Min.Y-p.Y, 0), p.Y-box.Max.Y)
+	dz := math.Max(math.Max(box.Min.Z-p.Z, 0), p.Z-box.Max.Z)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// Returns the closest point to p on the triangle and its barycentric weights.
+// From Real-Time Collision Detection by Christer Ericson.
+func closestPointOnTriangle(p objectfile.Vector, tri [3]objectfile.Vector) (objectfile.Vector, [3]float64) {
+	a, b, c := tri[0], tri[1], tri[2]
+	ab, ac, ap := b.Sub(a), c.Sub(a), p.Sub(a)
+	d1, d2 := ab.Dot(ap), ac.Dot(ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a, [3]float64{1, 0, 0}
+	}
+	bp := p.Sub(b)
+	d3, d4 := ab.Dot(bp), ac.Dot(bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b, [3]float64{0, 1, 0}
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		v := d1 / (d1 - d3)
+		return a.Add(ab.Scale(v)), [3]float64{1 - v, v, 0}
+	}
+	cp := p.Sub(c)
+	d5, d6 := ab.Dot(cp), ac.Dot(cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c, [3]float64{0, 0, 1}
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		w := d2 / (d2 - d6)
+		return a.Add(ac.Scale(w)), [3]float64{1 - w, 0, w}
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		w := (d4 - d3) / ((d4 - d3) + (d5 - d6))
+		return b.Add(c.Sub(b).Scale(w)), [3]float64{0, 1 - w, w}
+	}
+	if va+vb+vc == 0 {
+		// degenerate triangle
+		return a, [3]float64{1, 0, 0}
+	}
+	denom := 1 / (va + vb + vc)
+	v, w := vb*denom, vc*denom
+	return a.Add(ab.Scale(v)).Add(ac.Scale(w)), [3]float64{1 - v - w, v, w}
+}
+
+// deviation accumulates the sample distances of one direction.
+type deviation struct {
+	samples, uvSamples, normalSamples int
+	maxDistance, sumDistanceSq        float64
+	maxUV, sumUVSq                    float64
+	maxAngle, sumAngle                float64
+}
+
+func (d *deviation) Add(other deviation) {
+	d.samples += other.samples
+	d.uvSamples += other.uvSamples
+	d.normalSamples += other.normalSamples
+	d.maxDistance = math.Max(d.maxDistance, other.maxDistance)
+	d.sumDistanceSq += other.sumDistanceSq
+	d.maxUV = math.Max(d.maxUV, other.maxUV)
+	d.sumUVSq += other.sumUVSq
+	d.maxAngle = math.Max(d.maxAngle, other.maxAngle)
+	d.sumAngle += other.sumAngle
+}
+
+// Measures the samples against the tree. The UV and normal deviation of a sample
+// is the smallest one of the closest triangles.
+func (d *deviation) Measure(samples []surfaceSample, tree *triangleTree, tolerance float64) {
+	var hits []triangleHit
+	for _, s := range samples {
+		var distance float64
+		distance, hits = tree.Closest(interpolate(s.tri.P, s.bary), tolerance, hits)
+		d.samples++
+		d.maxDistance = math.Max(d.maxDistance, distance)
+		d.sumDistanceSq += distance * distance
+
+		uv, angle := math.Inf(1), math.Inf(1)
+		normal := interpolate(s.tri.N, s.bary).Normalize()
+		for _, hit := range hits {
+			if s.tri.HasUV && hit.tri.HasUV {
+				uv = math.Min(uv, interpolate(s.tri.UV, s.bary).Sub(interpolate(hit.tri.UV, hit.bary)).Length())
+			}
+			if s.tri.HasNormal && hit.tri.HasNormal {
+				if other := interpolate(hit.tri.N, hit.bary).Normalize(); normal.Length() > 0 && other.Length() > 0 {
+					cos := math.Max(-1, math.Min(1, normal.Dot(other)))
+					angle = math.Min(angle, math.Acos(cos)*180/math.Pi)
+				}
+			}
+		}
+		if !math.IsInf(uv, 1) {
+			d.uvSamples++
+			d.maxUV = math.Max(d.maxUV, uv)
+			d.sumUVSq += uv * uv
+		}
+		if !math.IsInf(angle, 1) {
+			d.normalSamples++
+			d.maxAngle = math.Max(d.maxAngle, angle)
+			d.sumAngle += angle
+		}
+	}
+}
+
+// Compares the surfaces per material in both directions. Names identify
+// the models in the failures eg. "input" and "output".
+func compareSurfaces(ctx context.Context, a, b *surface, nameA, nameB string, options VerifyOptions) (*CompareResult, error) {
+	result := &CompareResult{
+		Diagonal:  a.Box.Diagonal(),
+		Materials: make([]*CompareMaterialResult, 0),
+		Failures:  make([]string, 0),
+	}
+	// UVs and normals are compared to the best matching triangle within the allowed distance,
+	// UV seams and hard edges have multiple triangles at the same position
+	tolerance := math.Max(math.Max(options.VerifyMaxDistance, 0)*result.Diagonal, math.Max(result.Diagonal, 1)*1e-9)
+
+	materials := append([]string(nil), a.Materials...)
+	for _, material := range b.Materials {
+		if !containsString(materials, material) {
+			materials = append(materials, material)
+		}
+	}
+	totalArea := 0.0
+	for _, material := range a.Materials {
+		totalArea += a.Area(material)
+	}
+
+	var total deviation
+	for _, material := range materials {
+		m := &CompareMaterialResult{Material: material}
+		result.Materials = append(result.Materials, m)
+		trianglesA, trianglesB := a.Triangles[material], b.Triangles[material]
+		if len(trianglesA) == 0 || len(trianglesB) == 0 {
+			m.Missing = nameB
+			if len(trianglesA) == 0 {
+				m.Missing = nameA
+			}
+			continue
+		}
+
+		// random samples are split by area, b uses the same density as a
+		samples := 0
+		if totalArea > 0 {
+			samples = int(float64(options.VerifySamples) * a.Area(material) / totalArea)
+		}
+		samplesB := samples
+		if areaA := a.Area(material); areaA > 0 {
+			samplesB = int(float64(samples) * b.Area(material) / areaA)
+		}
+
+		var d deviation
+		for _, direction := range []struct {
+			samples []surfaceSample
+			tree    *triangleTree
+		}{
+			{sampleTriangles(trianglesA, samples), newTriangleTree(trianglesB)},
+			{sampleTriangles(trianglesB, samplesB), newTriangleTree(trianglesA)},
+		} {
+			measured, err := measureParallel(ctx, direction.samples, direction.tree, tolerance)
+			if err != nil {
+				return nil, err
+			}
+			d.Add(measured)
+		}
+		total.Add(d)
+
+		m.Samples = d.samples
+		m.Hausdorff = d.maxDistance
+		m.RMS = math.Sqrt(d.sumDistanceSq / float64(d.samples))
+		m.UVMax = d.maxUV
+		m.NormalAngleMax = d.maxAngle
+		if d.uvSamples > 0 {
+			m.UVRMS = math.Sqrt(d.sumUVSq / float64(d.uvSamples))
+		}
+		if d.normalSamples > 0 {
+			m.NormalAngleMean = d.sumAngle / float64(d.normalSamples)
+		}
+	}
+	result.Samples = total.samples
+	result.Hausdorff = total.maxDistance
+	result.UVMax = total.maxUV
+	result.NormalAngleMax = total.maxAngle
+	if total.samples > 0 {
+		result.RMS = math.Sqrt(total.sumDistanceSq / float64(total.samples))
+	}
+	result.check(options)
+	return result, nil
+}
+
+// Measures the samples with the worker goroutines of ctx, see withWorkers.
+func measureParallel(ctx context.Context, samples []surfaceSample, tree *triangleTree, tolerance float64) (deviation, error) {
+	const chunkSize = 1024
+	var (
+		total   deviation
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		next    = make(chan []surfaceSample)
+		workers = contextWorkers(ctx)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var d deviation
+			for chunk := range next {
+				d.Measure(chunk, tree, tolerance)
+			}
+			mu.Lock()
+			total.Add(d)
+			mu.Unlock()
+		}()
+	}
+	var err error
+	for start := 0; start < len(samples); start += chunkSize {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		end := start + chunkSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		next <- samples[start:end]
+	}
+	close(next)
+	wg.Wait()
+	return total, err
+}
+
+// Records the exceeded thresholds to Failures.
+func (r *CompareResult) check(options VerifyOptions) {
+	fail := func(format string, args ...interface{}) {
+		r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+	}
+	for _, m := range r.Materials {
+		name := materialLabel(m.Material)
+		if len(m.Missing) > 0 {
+			fail("material %s has no faces in %s", name, m.Missing)
+			continue
+		}
+		if options.VerifyMaxDistance >= 0 && m.Hausdorff > options.VerifyMaxDistance*r.Diagonal {
+			fail("material %s Hausdorff distance %g exceeds -verify-max-distance %g (%g)", name, m.Hausdorff, options.VerifyMaxDistance, options.VerifyMaxDistance*r.Diagonal)
+		}
+		if options.VerifyMaxRMS >= 0 && m.RMS > options.VerifyMaxRMS*r.Diagonal {
+			fail("material %s RMS distance %g exceeds -verify-max-rms %g (%g)", name, m.RMS, options.VerifyMaxRMS, options.VerifyMaxRMS*r.Diagonal)
+		}
+		if options.VerifyMaxUV >= 0 && m.UVMax > options.VerifyMaxUV {
+			fail("material %s UV deviation %g exceeds -verify-max-uv %g", name, m.UVMax, options.VerifyMaxUV)
+		}
+		if options.VerifyMaxNormalAngle >= 0 && m.NormalAngleMax > options.VerifyMaxNormalAngle {
+			fail("material %s normal deviation %.2f° exceeds -verify-max-normal-angle %g°", name, m.NormalAngleMax, options.VerifyMaxNormalAngle)
+		}
+	}
+}
+
+// Returns an error listing the failures, nil if there are none.
+func (r *CompareResult) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d thresholds exceeded: %s", len(r.Failures), strings.Join(r.Failures, ", "))
+}
+
+func materialLabel(material string) string {
+	if len(material) == 0 {
+		return "<none>"
+	}
+	return material
+}
+
+// Percent of the diagonal eg. "0.012%".
+func formatDiagonalPerc(distance, diagonal float64) string {
+	if diagonal <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.3g%%", distance/diagonal*100)
+}
+
+func logCompareResult(r *CompareResult) {
+	logInfo(" ")
+	logInfo("  %-24s %10s %12s %9s %12s %9s %10s %10s", "Material", "Samples", "Hausdorff", "", "RMS", "", "UV max", "Normal max")
+	for _, m := range r.Materials {
+		if len(m.Missing) > 0 {
+			logInfo("  %-24s no faces in %s", materialLabel(m.Material), m.Missing)
+			continue
+		}
+		logInfo("  %-24s %10s %12.6g %9s %12.6g %9s %10.4g %9.2f°", materialLabel(m.Material), formatInt(m.Samples),
+			m.Hausdorff, formatDiagonalPerc(m.Hausdorff, r.Diagonal), m.RMS, formatDiagonalPerc(m.RMS, r.Diagonal), m.UVMax, m.NormalAngleMax)
+	}
+	logInfo("  %-24s %10s %12.6g %9s %12.6g %9s %10.4g %9.2f°", "Total", formatInt(r.Samples),
+		r.Hausdorff, formatDiagonalPerc(r.Hausdorff, r.Diagonal), r.RMS, formatDiagonalPerc(r.RMS, r.Diagonal), r.UVMax, r.NormalAngleMax)
+	logInfo(" ")
+	if len(r.Failures) == 0 {
+		logInfo("  Within thresholds")
+	}
+	for _, failure := range r.Failures {
+		logInfo("  FAIL %s", failure)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// configFile is the -config file and -preset format. Options are keyed by
+// their flag name, explicit command line flags override them.
+type configFile struct {
+	// Preset the file extends, -preset overrides it.
+	Preset string `json:"preset,omitempty" yaml:"preset,omitempty" toml:"preset,omitempty"`
+	Format string `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
+	// Processor run order by name, see -pipeline.
+	Pipeline []string `json:"pipeline,omitempty" yaml:"pipeline,omitempty" toml:"pipeline,omitempty"`
+	// Processor options by processor name. "disabled" is the -no-<name> flag.
+	Processors map[string]map[string]interface{} `json:"processors,omitempty" yaml:"processors,omitempty" toml:"processors,omitempty"`
+	Writer     map[string]interface{}            `json:"writer,omitempty" yaml:"writer,omitempty" toml:"writer,omitempty"`
+	// Plugin commands by name, see -plugin. -plugin definitions take precedence.
+	Plugins map[string]string `json:"plugins,omitempty" yaml:"plugins,omitempty" toml:"plugins,omitempty"`
+	// Other flags eg. workers and strict.
+	Options map[string]interface{} `json:"options,omitempty" yaml:"options,omitempty" toml:"options,omitempty"`
+}
+
+// Flags of processors without an options struct and the writers,
+// the remaining ones are set in the options section.
+var (
+	processorOptions = map[string][]string{
+		"quantize": {"quantize-v", "quantize-vt"},
+	}
+	writerOptions = []string{
+		"gzip", "precision-v", "precision-vn", "precision-vt", "precision-vp", "float32",
+		"header", "no-header", "deterministic", "compact", "json-bin",
+	}
+	// flags that can't be set in a config file
+	configIgnoredOptions = []string{"in", "config", "preset", "pipeline", "plugin", "version", "cpu-profile"}
+)
+
+var Presets = map[string]*configFile{
+	// small files for HTTP delivery
+	"web": &configFile{
+		Processors: map[string]map[string]interface{}{
+			"duplicates": {"epsilon-vn-angle": 1.0},
+		},
+		Writer: map[string]interface{}{
+			"gzip": 9, "compact": true, "float32": true, "precision-vn": "4f", "precision-vt": "5f",
+		},
+	},
+	// watertight geometry for slicers, materials are written as separate STL files
+	"print": &configFile{
+		Format:   "stl",
+		Pipeline: []string{"duplicates", "faces"},
+		Processors: map[string]map[string]interface{}{
+			"duplicates": {"epsilon-v": 1e-5},
+		},
+	},
+	// lossless and reproducible
+	"archive": &configFile{
+		Pipeline: []string{"duplicates", "faces", "merge"},
+		Writer: map[string]interface{}{
+			"gzip": 9, "deterministic": true,
+		},
+	},
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reads a .json, .yaml/.yml or .toml config file.
+func loadConfig(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &configFile{}
+	switch ext := fileExtension(path); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(data), c); err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown key %q", undecoded[0].String())
+			}
+		}
+	default:
+		return nil, fmt.Errorf("config file %s must be .json, .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Returns the processor name used in config files eg. "duplicates".
+func configName(p *processor) string {
+	return strings.ToLower(p.Name())
+}
+
+// Returns the flag names of the processor options.
+func processorOptionNames(p *processor) []string {
+	if cp, ok := p.Processor.(ContextProcessor); ok {
+		return optionNames(cp.Options())
+	}
+	return processorOptions[configName(p)]
+}
+
+func findProcessor(name string) *processor {
+	for _, p := range Processors {
+		if configName(p) == strings.ToLower(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Returns the flag values of c keyed by flag name.
+func (c *configFile) values() (map[string]string, error) {
+	values := make(map[string]string)
+	set := func(section, name string, value interface{}, allowed func(string) bool) error {
+		if flag.Lookup(name) == nil || containsString(configIgnoredOptions, name) || !allowed(name) {
+			return fmt.Errorf("%q is not a %s option", name, section)
+		}
+		values[name] = configValue(value)
+		return nil
+	}
+	for name, options := range c.Processors {
+		p := findProcessor(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown processor %q", name)
+		}
+		for option, value := range options {
+			if option == "disabled" {
+				option = p.NameCmd()
+			}
+			owned := func(o string) bool { return o == p.NameCmd() || containsString(processorOptionNames(p), o) }
+			if err := set(configName(p), option, value, owned); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for option, value := range c.Writer {
+		if err := set("writer", option, value, func(o string) bool { return containsString(writerOptions, o) }); err != nil {
+			return nil, err
+		}
+	}
+	for option, value := range c.Options {
+		if err := set("general", option, value, func(o string) bool { return !isSectionOption(o) }); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Format) > 0 {
+		values["format"] = c.Format
+	}
+	if len(c.Pipeline) > 0 {
+		values["pipeline"] = strings.Join(c.Pipeline, ",")
+	}
+	return values, nil
+}
+
+// Formats a decoded value as a flag value. JSON numbers are float64, they are
+// written without an exponent so integer flags can parse them.
+func configValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Reports if flag name belongs to the processors or writer section.
+func isSectionOption(name string) bool {
+	if containsString(writerOptions, name) {
+		return true
+	}
+	for _, p := range Processors {
+		if name == p.NameCmd() || containsString(processorOptionNames(p), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the -preset and -config file on top of the defaults. Flags
+// in explicit were given on the command line and are not changed.
+func applyConfig(path, preset string, explicit map[string]bool) error {
+	var configs []*configFile
+	c := &configFile{}
+	if len(path) > 0 {
+		var err error
+		if c, err = loadConfig(path); err != nil {
+			return err
+		}
+	}
+	if len(preset) == 0 {
+		preset = c.Preset
+	}
+	if len(preset) > 0 {
+		p, ok := Presets[preset]
+		if !ok {
+			return fmt.Errorf("unknown preset %q, use one of: %s", preset, strings.Join(presetNames(), ", "))
+		}
+		configs = append(configs, p)
+		StartParams.Preset = preset
+	}
+	configs = append(configs, c)
+
+	for _, c := range configs {
+		values, err := c.values()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(c.Plugins))
+		for name := range c.Plugins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if findPlugin(name) == nil {
+				if err := addPlugin(name, c.Plugins[name]); err != nil {
+					return err
+				}
+			}
+		}
+		for name, value := range values {
+			if explicit[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %s", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the effective configuration, logged on start like StartParams.
+func effectiveConfig() *configFile {
+	get := func(name string) interface{} {
+		return flag.Lookup(name).Value.(flag.Getter).Get()
+	}
+	c := &configFile{
+		Preset:     StartParams.Preset,
+		Format:     StartParams.OutputEncoder().Name(),
+		Processors: make(map[string]map[string]interface{}),
+		Writer:     make(map[string]interface{}),
+		Plugins:    pluginCommands(),
+	}
+	for _, p := range Pipeline {
+		if !p.Disabled {
+			c.Pipeline = append(c.Pipeline, configName(p))
+		}
+	}
+	for _, p := range Processors {
+		if options := processorOptionNames(p); len(options) > 0 {
+			c.Processors[configName(p)] = make(map[string]interface{})
+			for _, option := range options {
+				c.Processors[configName(p)][option] = get(option)
+			}
+		}
+	}
+	for _, option := range writerOptions {
+		c.Writer[option] = get(option)
+	}
+	return c
+}
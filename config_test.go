@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// JSON numbers are float64, large integers must not be written with an exponent.
+func TestConfigNumbers(t *testing.T) {
+	defer func(sp startParams) { StartParams = sp }(StartParams)
+	for _, p := range Processors {
+		if d, ok := p.Processor.(*Duplicates); ok {
+			defer saveOptions(&d.options)()
+		}
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"config.json": `{"options": {"verify-samples": 1000000}, "processors": {"duplicates": {"epsilon": 0.0000001}}}`,
+		"config.yaml": "options:\n  verify-samples: 1000000\nprocessors:\n  duplicates:\n    epsilon: 0.0000001\n",
+		"config.toml": "[options]\nverify-samples = 1000000\n[processors.duplicates]\nepsilon = 0.0000001\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		StartParams.VerifySamples = 0
+		if err := applyConfig(path, "", nil); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if StartParams.VerifySamples != 1000000 {
+			t.Errorf("%s: -verify-samples is %d, want 1000000", name, StartParams.VerifySamples)
+		}
+		if epsilon := geometryTolerances(nil)[objectfile.Vertex].Epsilon; epsilon != 1e-7 {
+			t.Errorf("%s: -epsilon is %g, want 1e-7", name, epsilon)
+		}
+	}
+}
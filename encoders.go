@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Output formats, -format selects by name and -out by extension.
+// The first encoder is the default for unknown extensions.
+var Encoders = []Encoder{
+	ObjEncoder{},
+	PlyEncoder{},
+	StlEncoder{},
+	ThreejsEncoder{},
+	UsdEncoder{},
+	OffEncoder{},
+	X3dEncoder{},
+	ColladaEncoder{},
+}
+
+type Encoder interface {
+	Name() string
+	Desc() string
+	// Lower-cased with a "." prefix, the first one is used for default output names.
+	Extensions() []string
+	// Encodes obj to w and returns the number of lines or elements written.
+	// path is the output file or "" for stdout, encoders that reference
+	// other files eg. textures write paths relative to it.
+	Encode(w io.Writer, obj *objectfile.OBJ, path string) (int, error)
+}
+
+// multiFileEncoder is implemented by encoders that write more than one file.
+type multiFileEncoder interface {
+	EncodeFiles(obj *objectfile.OBJ, path string) (files []string, written int, err error)
+}
+
+func findEncoder(name string) Encoder {
+	for _, enc := range Encoders {
+		if strings.EqualFold(enc.Name(), name) {
+			return enc
+		}
+	}
+	return nil
+}
+
+// Returns the encoder for the extension of path, compression
+// extensions are ignored eg. "model.ply.gz" is PLY.
+func findEncoderForPath(path string) Encoder {
+	ext := fileExtension(trimCompressionExtension(path))
+	for _, enc := range Encoders {
+		for _, encExt := range enc.Extensions() {
+			if ext == encExt {
+				return enc
+			}
+		}
+	}
+	return nil
+}
+
+func encoderNames() []string {
+	names := make([]string, len(Encoders))
+	for i, enc := range Encoders {
+		names[i] = enc.Name()
+	}
+	return names
+}
+
+// Writes obj to path and returns the written files.
+func encodeFile(enc Encoder, obj *objectfile.OBJ, path string) ([]string, int, error) {
+	if multi, ok := enc.(multiFileEncoder); ok {
+		return multi.EncodeFiles(obj, path)
+	}
+	written, err := writeFile(path, func(w io.Writer) (int, error) {
+		return enc.Encode(w, obj, path)
+	})
+	return []string{path}, written, err
+}
+
+var invalidIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// Returns name as an identifier that is valid in USD, XML and most other formats
+// and is not in used. The returned identifier is added to used.
+func uniqueIdentifier(name string, used map[string]bool) string {
+	id := strings.Trim(invalidIdentifierChars.ReplaceAllString(name, "_"), "_")
+	if len(id) == 0 {
+		id = "unnamed"
+	} else if id[0] >= '0' && id[0] <= '9' {
+		id = "_" + id
+	}
+	unique := id
+	for i := 1; used[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", id, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// Texture paths are relative to the mtl file, returns texture relative to
+// the output file. Stdout output uses paths relative to the working directory.
+func outputTexturePath(material *objectfile.Material, texture, output string) string {
+	texture = filepath.ToSlash(texture)
+	if filepath.IsAbs(texture) || StartParams.Input == stdinPath {
+		return texture
+	}
+	outDir := "."
+	if len(output) > 0 {
+		outDir = filepath.Dir(output)
+	}
+	mtlDir := filepath.Join(filepath.Dir(StartParams.Input), filepath.Dir(material.Mtllib))
+	abs, errAbs := filepath.Abs(filepath.Join(mtlDir, texture))
+	dir, errDir := filepath.Abs(outDir)
+	if errAbs != nil || errDir != nil {
+		return texture
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return texture
+}
+
+// Returns the object name or the input file name for stdout.
+func outputName(path string) string {
+	if len(path) == 0 {
+		return fileBasename(StartParams.Input)
+	}
+	return fileBasename(trimCompressionExtension(path))
+}
+
+// Counts the lines of text encoders.
+type lineCountingWriter struct {
+	w     io.Writer
+	lines int
+}
+
+func (lw *lineCountingWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			lw.lines++
+		}
+	}
+	return lw.w.Write(p)
+}
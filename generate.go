@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// GenerateOptions describes a synthetic model, see generateOBJ.
+type GenerateOptions struct {
+	Vertices   int     `flag:"vertices" usage:"Unique vertex positions, rounded up to a square grid."`
+	Duplicates float64 `flag:"duplicates" usage:"Fraction of vertices, UVs and normals declared a second time."`
+	Noise      float64 `flag:"noise" usage:"Max offset of the second declarations. Below -epsilon (default 1e-6) they are merged as duplicates, above it they are kept."`
+	Objects    int     `flag:"objects" usage:"Number of objects, horizontal bands of the grid."`
+	Groups     bool    `flag:"groups" usage:"Declare the bands with g instead of o."`
+	Materials  int     `flag:"materials" usage:"Materials per object, switched with usemtl mid object."`
+	Relative   bool    `flag:"relative" usage:"Write negative face indexes relative to the declared geometry."`
+	Triangles  float64 `flag:"triangles" usage:"Fraction of grid cells written as two triangles instead of a quad."`
+	NGons      float64 `flag:"ngons" usage:"Fraction of grid cells written as a hexagon together with the next cell."`
+	Seed       int     `flag:"seed" usage:"Random seed, the same options always produce the same file."`
+}
+
+func (o *GenerateOptions) Validate() error {
+	switch {
+	case o.Vertices < 1 || o.Vertices > 1<<29:
+		return fmt.Errorf("-vertices must be between 1 and %d, given: %d", 1<<29, o.Vertices)
+	case o.Objects < 1 || o.Materials < 1:
+		return fmt.Errorf("-objects and -materials must be positive, given: %d and %d", o.Objects, o.Materials)
+	case o.Duplicates < 0 || o.Duplicates > 1:
+		return fmt.Errorf("-duplicates must be between 0 and 1, given: %g", o.Duplicates)
+	case o.Noise < 0:
+		return fmt.Errorf("-noise can't be negative, given: %g", o.Noise)
+	case o.Triangles < 0 || o.NGons < 0 || o.Triangles+o.NGons > 1:
+		return fmt.Errorf("-triangles and -ngons must be between 0 and 1 in total, given: %g and %g", o.Triangles, o.NGons)
+	}
+	return nil
+}
+
+// Writes a wavy height field grid of quads, triangles and hexagons to w. The same options always
+// produce the same bytes. Geometry is streamed, memory use is a few bytes
+// per grid point so huge files can be generated. Returns the number of lines written.
+func generateOBJ(w io.Writer, opts GenerateOptions) (int, error) {
+	var (
+		bw    = bufio.NewWriterSize(w, 256*1024)
+		rng   = rand.New(rand.NewSource(int64(opts.Seed)))
+		side  = int(math.Max(2, math.Ceil(math.Sqrt(float64(opts.Vertices)))))
+		lines = 0
+		line  []byte
+	)
+	// first declared index of each grid point, duplicated points are declared twice in a row
+	first := make([]int32, side*side)
+	duplicated := make([]bool, side*side)
+
+	writeLine := func() {
+		line = append(line, '\n')
+		bw.Write(line)
+		lines++
+	}
+	appendFloats := func(prefix string, noise float64, values ...float64) {
+		line = append(line[:0], prefix...)
+		for _, f := range values {
+			if noise > 0 {
+				f += (rng.Float64()*2 - 1) * noise
+			}
+			line = append(line, ' ')
+			line = strconv.AppendFloat(line, f, 'g', -1, 64)
+		}
+		writeLine()
+	}
+
+	fmt.Fprintf(bw, "# Generated by %s, seed %d\n", ApplicationName, opts.Seed)
+	lines++
+
+	index := int32(0)
+	for i := range first {
+		var (
+			u, v = float64(i%side) / float64(side-1), float64(i/side) / float64(side-1)
+			sx   = math.Sin(2 * math.Pi * u)
+			cz   = math.Cos(2 * math.Pi * v)
+			// height 0.1 * sx * cz and its normal
+			nx, nz = -0.2 * math.Pi * math.Cos(2*math.Pi*u) * cz, 0.2 * math.Pi * sx * math.Sin(2*math.Pi*v)
+			nl     = math.Sqrt(nx*nx + 1 + nz*nz)
+		)
+		copies := 1
+		if rng.Float64() < opts.Duplicates {
+			copies = 2
+			duplicated[i] = true
+		}
+		index++
+		first[i] = index
+		for c := 0; c < copies; c++ {
+			noise := 0.0
+			if c > 0 {
+				noise = opts.Noise
+			}
+			appendFloats("v", noise, u, 0.1*sx*cz, v)
+			appendFloats("vt", noise, u, v)
+			appendFloats("vn", noise, nx/nl, 1/nl, nz/nl)
+		}
+		index += int32(copies - 1)
+	}
+
+	// references alternate between the declarations of duplicated points so
+	// all of them are used and can be removed by Duplicates
+	uses := make([]uint8, side*side)
+	corner := func(x, z int) int32 {
+		i := z*side + x
+		if !duplicated[i] {
+			return first[i]
+		}
+		uses[i]++
+		return first[i] + int32(uses[i]%2)
+	}
+	writeFace := func(corners ...int32) {
+		line = append(line[:0], 'f')
+		for _, c := range corners {
+			// -relative: all geometry is declared before the faces
+			if opts.Relative {
+				c = c - index - 1
+			}
+			line = append(line, ' ')
+			line = strconv.AppendInt(line, int64(c), 10)
+			line = append(line, '/')
+			line = strconv.AppendInt(line, int64(c), 10)
+			line = append(line, '/')
+			line = strconv.AppendInt(line, int64(c), 10)
+		}
+		writeLine()
+	}
+	var (
+		rows      = side - 1
+		objects   = int(math.Max(1, math.Min(float64(opts.Objects), float64(rows))))
+		materials = int(math.Max(1, float64(opts.Materials)))
+		childType = "o"
+	)
+	if opts.Groups {
+		childType = "g"
+	}
+	for o := 0; o < objects; o++ {
+		fmt.Fprintf(bw, "%s object%d\ns 1\n", childType, o+1)
+		lines += 2
+		start, end := o*rows/objects*rows, (o+1)*rows/objects*rows
+		current := -1
+		for cell := start; cell < end; cell++ {
+			if m := (cell - start) * materials / (end - start); m != current {
+				fmt.Fprintf(bw, "usemtl material%d\n", m+1)
+				lines++
+				current = m
+			}
+			x, z := cell%rows, cell/rows
+			a, b, c, d := corner(x, z), corner(x, z+1), corner(x+1, z+1), corner(x+1, z)
+			switch r := rng.Float64(); {
+			case r < opts.Triangles:
+				writeFace(a, b, c)
+				writeFace(a, c, d)
+			case r < opts.Triangles+opts.NGons && x+1 < rows && cell+1 < end:
+				writeFace(a, b, c, corner(x+2, z+1), corner(x+2, z), d)
+				cell++
+			default:
+				writeFace(a, b, c, d)
+			}
+		}
+	}
+	return lines, bw.Flush()
+}
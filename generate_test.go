@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+func TestGenerateOBJ(t *testing.T) {
+	opts := GenerateOptions{Vertices: 1000, Duplicates: 0.25, Noise: 1e-7, Objects: 3, Materials: 2, Seed: 7}
+	var a, b bytes.Buffer
+	lines, err := generateOBJ(&a, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generateOBJ(&b, opts)
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("same options generated different output")
+	}
+	if n := bytes.Count(a.Bytes(), []byte("\n")); n != lines {
+		t.Fatalf("%d lines written, %d reported", n, lines)
+	}
+
+	obj, _, err := parse(&a, "generated.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReferences(t, obj)
+	stats := obj.Stats()
+	// 32x32 grid
+	if stats.Geometry.Vertices <= 1024 || stats.Geometry.Vertices > 2048 {
+		t.Fatalf("%d vertices for 1024 grid points with duplicates", stats.Geometry.Vertices)
+	} else if stats.Faces != 31*31 {
+		t.Fatalf("%d faces, want %d", stats.Faces, 31*31)
+	} else if len(obj.Objects) != 3*2 {
+		t.Fatalf("%d objects, want 3 with 2 materials each", len(obj.Objects))
+	}
+}
+
+// Relative indexes parse to the same model, n-gon mix and groups are written.
+func TestGenerateOBJOptions(t *testing.T) {
+	processed := make([][]byte, 0, 2)
+	for _, relative := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := GenerateOptions{Vertices: 400, Duplicates: 0.5, Noise: 1e-7, Objects: 2, Groups: true, Materials: 2, Relative: relative, Triangles: 0.3, NGons: 0.3, Seed: 3}
+		if err := opts.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := generateOBJ(&buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		if relative != bytes.Contains(buf.Bytes(), []byte("f -")) {
+			t.Fatalf("relative %t, wrote:\n%s", relative, buf.Bytes())
+		}
+		obj, _, err := parse(&buf, "generated.obj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		corners := make(map[int]int)
+		for _, child := range obj.Objects {
+			if child.Type != objectfile.ChildGroup {
+				t.Fatalf("%s %s, want g", child.Type, child.Name)
+			}
+			for _, vd := range child.VertexData {
+				corners[len(vd.Declarations)]++
+			}
+		}
+		if len(corners) != 3 || corners[3] == 0 || corners[4] == 0 || corners[6] == 0 {
+			t.Fatalf("faces by corner count %v, want triangles, quads and hexagons", corners)
+		}
+		processed = append(processed, processForTest(t, obj, 4))
+	}
+	if !bytes.Equal(processed[0], processed[1]) {
+		t.Fatal("relative and absolute indexes processed differently")
+	}
+	if stats := bytes.Count(processed[0], []byte("\nv ")); stats != 400 {
+		t.Fatalf("%d vertices after removing duplicates, want 400", stats)
+	}
+
+	for _, opts := range []GenerateOptions{
+		{Vertices: 0, Objects: 1, Materials: 1},
+		{Vertices: 10, Objects: 1, Materials: 0},
+		{Vertices: 10, Objects: 1, Materials: 1, Duplicates: 1.5},
+		{Vertices: 10, Objects: 1, Materials: 1, Triangles: 0.6, NGons: 0.6},
+	} {
+		if opts.Validate() == nil {
+			t.Errorf("%+v is valid", opts)
+		}
+	}
+}
+
+var generatedForBenchmark = make(map[int][]byte)
+
+// Returns a generated OBJ with about vertices unique positions, 20% of them duplicated.
+func generateForBenchmark(b *testing.B, vertices int) []byte {
+	b.Helper()
+	if data, ok := generatedForBenchmark[vertices]; ok {
+		return data
+	}
+	var buf bytes.Buffer
+	if _, err := generateOBJ(&buf, GenerateOptions{Vertices: vertices, Duplicates: 0.2, Noise: 1e-7, Objects: 8, Materials: 2, Seed: 1}); err != nil {
+		b.Fatal(err)
+	}
+	generatedForBenchmark[vertices] = buf.Bytes()
+	return buf.Bytes()
+}
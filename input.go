@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Input path that reads from stdin.
+const stdinPath = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+
+	// Max size of a zip archive, the decompressed model and the other archive
+	// files in total. 0 is unlimited, serve sets it to -max-size.
+	maxInputSize int64
+	// Set by serve, mtllib files are only looked up by their file name from
+	// the input directory so an uploaded model can't read files outside it.
+	confineMtllibs bool
+
+	errInputTooLarge = errors.New("input is too large")
+)
+
+// inputFile is an OBJ, STL or PLY source that is transparently decompressed.
+// Compression is detected from the magic bytes, not the file extension.
+type inputFile struct {
+	// Name of the model file without directory or compression
+	// suffixes eg. "model.obj" for "/path/model.obj.gz".
+	Name string
+	// Uncompressed model data.
+	Reader io.Reader
+	// Other files found next to the model in a zip archive keyed
+	// by their base name eg. "model.mtl".
+	Files map[string][]byte
+
+	source  *countingReader
+	closers []io.Closer
+}
+
+// Opens path or stdin for "-". Gzip data and zip archives containing
+// an .obj, .stl or .ply file are decompressed.
+func openInput(path string) (*inputFile, error) {
+	in := &inputFile{
+		Files: make(map[string][]byte),
+	}
+	var src io.Reader
+	if path == stdinPath {
+		in.Name = "stdin"
+		src = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		in.Name = filepath.Base(path)
+		in.closers = append(in.closers, f)
+		src = f
+	}
+	in.source = &countingReader{r: src}
+	if err := in.detect(bufio.NewReader(in.source)); err != nil {
+		in.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+func (in *inputFile) detect(r *bufio.Reader) error {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		in.closers = append(in.closers, gz)
+		in.Reader = limitInput(gz, in.Name, maxInputSize)
+		in.Name = trimCompressionExtension(in.Name)
+	case bytes.HasPrefix(magic, zipMagic):
+		return in.readZip(r)
+	default:
+		in.Reader = r
+	}
+	return nil
+}
+
+// Zip needs random access, the archive is read to memory.
+func (in *inputFile) readZip(r io.Reader) error {
+	data, err := io.ReadAll(limitInput(r, in.Name, maxInputSize))
+	if err != nil {
+		return err
+	}
+	// other files share the limit, the model is streamed
+	remaining := maxInputSize
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	var modelFile *zip.File
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if isModelExtension(fileExtension(file.Name)) {
+			if modelFile != nil {
+				logWarn("Zip archive contains multiple model files, using %s and ignoring %s", modelFile.Name, file.Name)
+				continue
+			}
+			modelFile = file
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		if maxInputSize > 0 && remaining <= 0 {
+			rc.Close()
+			return fmt.Errorf("%s: %w, the files are larger than %s in total", in.Name, errInputTooLarge, formatBytes(maxInputSize))
+		}
+		b, err := io.ReadAll(limitInput(rc, file.Name, remaining))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		remaining -= int64(len(b))
+		in.Files[filepath.Base(file.Name)] = b
+	}
+	if modelFile == nil {
+		return fmt.Errorf("zip archive %s does not contain an .obj, .stl or .ply file", in.Name)
+	}
+	rc, err := modelFile.Open()
+	if err != nil {
+		return err
+	}
+	in.closers = append(in.closers, rc)
+	in.Name = filepath.Base(modelFile.Name)
+	in.Reader = limitInput(rc, modelFile.Name, maxInputSize)
+	return nil
+}
+
+func isModelExtension(ext string) bool {
+	return ext == ".obj" || ext == ".stl" || ext == ".ply"
+}
+
+// Number of bytes read from the source, before decompression.
+func (in *inputFile) BytesRead() int64 {
+	return in.source.n
+}
+
+// Writes .mtl files that were found in an archive to dir.
+// Existing files are not overwritten.
+func (in *inputFile) WriteMaterialLibraries(dir string) error {
+	names := make([]string, 0, len(in.Files))
+	for name := range in.Files {
+		if fileExtension(name) == ".mtl" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data := in.Files[name]
+		path := filepath.Join(dir, name)
+		if fileExists(path) {
+			logWarn("Not overwriting existing %s with the one from the input archive", path)
+			continue
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		logInfo("Wrote %s from the input archive", path)
+	}
+	return nil
+}
+
+func (in *inputFile) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if cErr := in.closers[i].Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	in.closers = nil
+	return err
+}
+
+// Strips compression and archive extensions eg. "model.obj.gz" to "model.obj".
+func trimCompressionExtension(path string) string {
+	for _, ext := range []string{".gz", ".gzip", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(path), ext) {
+			return path[0 : len(path)-len(ext)]
+		}
+	}
+	return path
+}
+
+// Returns r that fails with errInputTooLarge after reading max bytes. max <= 0 is unlimited.
+func limitInput(r io.Reader, name string, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitedReader{r: io.LimitReader(r, max+1), name: name, max: max}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	name string
+	max  int64
+	n    int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.n += int64(n)
+	if lr.n > lr.max {
+		return n, fmt.Errorf("%s: %w, larger than %s decompressed", lr.name, errInputTooLarge, formatBytes(lr.max))
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -51,6 +51,7 @@ func logInfo(format string, args ...interface{}) {
 }
 
 func logWarn(format string, args ...interface{}) {
+	Report.AddWarning(fmt.Sprintf(format, args...))
 	format = "[WARN] " + format
 	if !StartParams.Quiet {
 		logRaw(format, args...)
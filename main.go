@@ -2,11 +2,14 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,8 +19,18 @@ import (
 
 var (
 	StartParams = startParams{
-		Gzip:    -1,
-		Epsilon: 1e-6,
+		Gzip:     -1,
+		Progress: "bar",
+		VerifyOptions: VerifyOptions{
+			VerifySamples:        100000,
+			VerifyMaxDistance:    0.001,
+			VerifyMaxRMS:         0.0001,
+			VerifyMaxUV:          0.001,
+			VerifyMaxNormalAngle: 5,
+		},
+
+		WatchInterval: time.Second,
+		WatchDebounce: 500 * time.Millisecond,
 	}
 
 	ApplicationName = "obj-simplify"
@@ -27,112 +40,377 @@ var (
 	VersionDate     string
 
 	Processors = []*processor{
-		&processor{Processor: Duplicates{}},
+		&processor{Processor: Quantize{}},
+		&processor{Processor: newDuplicates()},
+		&processor{Processor: Faces{}},
 		&processor{Processor: Merge{}},
 	}
+	// Processors in the order they are run, see -pipeline.
+	Pipeline = defaultPipeline()
 )
 
 type startParams struct {
-	Input  string
-	Output string
+	Input    string
+	Output   string
+	Format   string
+	Report   string
+	Config   string
+	Preset   string
+	Pipeline string
 
 	Workers int
 	Gzip    int
-	Epsilon float64
+	Timeout time.Duration
+
+	// writer float formats per geometry type eg. "4f" or "6g"
+	PrecisionV  string
+	PrecisionVN string
+	PrecisionVT string
+	PrecisionVP string
+	Float32     bool
+
+	QuantizeV  float64
+	QuantizeVT float64
+
+	// -verify thresholds, also used by the compare command
+	VerifyOptions
+
+	Header        string
+	NoHeader      bool
+	Deterministic bool
+	Compact       bool
+	JsonBin       bool
 
 	Strict     bool
 	Stdout     bool
+	Analyze    bool
+	Verify     bool
 	Quiet      bool
 	NoProgress bool
+	Progress   string
 	CpuProfile bool
+
+	Watch         bool
+	WatchInterval time.Duration
+	WatchDebounce time.Duration
+}
+
+// Returns the -format encoder or the one for the -out extension, defaults to OBJ.
+func (sp startParams) OutputEncoder() Encoder {
+	if len(sp.Format) > 0 {
+		if enc := findEncoder(sp.Format); enc != nil {
+			return enc
+		}
+	} else if !sp.Stdout {
+		if enc := findEncoderForPath(sp.Output); enc != nil {
+			return enc
+		}
+	}
+	return Encoders[0]
+}
+
+// Returns the writer float format for geometry type t.
+func (sp startParams) FloatFormatFor(t objectfile.Type) objectfile.FloatFormat {
+	precision := ""
+	switch t {
+	case objectfile.Vertex:
+		precision = sp.PrecisionV
+	case objectfile.Normal:
+		precision = sp.PrecisionVN
+	case objectfile.UV:
+		precision = sp.PrecisionVT
+	case objectfile.Param:
+		precision = sp.PrecisionVP
+	}
+	// validated in parseStartParams
+	ff, _ := parseFloatFormat(precision)
+	ff.Float32 = sp.Float32
+	return ff
+}
+
+// Parses "<n>" or "<n>f" as n decimals and "<n>g" as n significant digits.
+// Empty string returns the default shortest representation.
+func parseFloatFormat(precision string) (objectfile.FloatFormat, error) {
+	ff := objectfile.DefaultFloatFormat
+	if len(precision) == 0 {
+		return ff, nil
+	}
+	verb := precision[len(precision)-1]
+	digits := precision
+	if verb == 'f' || verb == 'g' {
+		digits = precision[0 : len(precision)-1]
+	} else {
+		verb = 'f'
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 {
+		return ff, fmt.Errorf("invalid precision %q, use <n>f for decimals or <n>g for significant digits, n must be 1 or more", precision)
+	}
+	if verb == 'f' {
+		ff.Decimals = n
+	} else {
+		ff.Significant = n
+	}
+	return ff, nil
 }
 
 func (sp startParams) IsGzipEnabled() bool {
 	return sp.Gzip >= gzip.BestSpeed && sp.Gzip <= gzip.BestCompression
 }
 
-func init() {
-	version := false
+var (
+	showVersion bool
+)
 
+// Registers the flags, parsing is done in parseStartParams
+// so that commands can use their own flag sets.
+func init() {
 	StartParams.Workers = runtime.NumCPU() * 4
 	if StartParams.Workers < 4 {
 		StartParams.Workers = 4
 	}
 
 	flag.StringVar(&StartParams.Input,
-		"in", StartParams.Input, "Input file.")
+		"in", StartParams.Input, "Input .obj, .stl or .ply file, use - for stdin. Gzip and zip archives containing the model and its .mtl files are detected automatically.")
 	flag.StringVar(&StartParams.Output,
-		"out", StartParams.Output, "Output file or directory.")
+		"out", StartParams.Output, "Output file or directory. The extension selects the format if -format is not set, see -format.")
+	flag.StringVar(&StartParams.Format,
+		"format", StartParams.Format, "Output format: "+strings.Join(encoderNames(), ", ")+". Defaults to the -out extension, obj if not recognized.")
+
+	flag.StringVar(&StartParams.Report,
+		"report", StartParams.Report, "Write a machine readable JSON report of the run to this file.")
+	flag.StringVar(&StartParams.Config,
+		"config", StartParams.Config, "Read the processor pipeline, processor and writer options from a .json, .yaml or .toml file. Command line flags override the file.")
+	flag.StringVar(&StartParams.Pipeline,
+		"pipeline", StartParams.Pipeline, "Comma separated processor run order eg. duplicates,faces,merge,duplicates. Processors can be listed multiple times, unlisted ones are not run. Defaults to "+strings.Join(pipelineNames(defaultPipeline()), ",")+", faces is only run if listed.")
+	flag.Var(pluginFlag{},
+		"plugin", "Define an external processor as name=command, run it by adding the name to -pipeline. The model is piped through the command in the plugin JSON format, see the README. Can be repeated.")
+	flag.StringVar(&StartParams.Preset,
+		"preset", StartParams.Preset, "Named set of options: "+strings.Join(presetNames(), ", ")+". Applied before -config, overrides the preset of the -config file.")
 
 	flag.IntVar(&StartParams.Workers,
 		"workers", StartParams.Workers, "Number of worker goroutines.")
+	flag.DurationVar(&StartParams.Timeout,
+		"timeout", StartParams.Timeout, "Abort processing if it takes longer than this eg. 5m. <=0 disables.")
 	flag.IntVar(&StartParams.Gzip,
 		"gzip", StartParams.Gzip, "Gzip compression level on the output for both -stdout and -out. <=0 disables compression, use 1 (best speed) to 9 (best compression) to enable.")
-	flag.Float64Var(&StartParams.Epsilon,
-		"epsilon", StartParams.Epsilon, "Epsilon for float comparisons.")
+	flag.StringVar(&StartParams.PrecisionV,
+		"precision-v", StartParams.PrecisionV, "Output precision of vertex positions. <n>f for n decimals, <n>g for n significant digits. Default writes the shortest exact representation.")
+	flag.StringVar(&StartParams.PrecisionVN,
+		"precision-vn", StartParams.PrecisionVN, "Output precision of normals, see -precision-v.")
+	flag.StringVar(&StartParams.PrecisionVT,
+		"precision-vt", StartParams.PrecisionVT, "Output precision of UVs, see -precision-v.")
+	flag.StringVar(&StartParams.PrecisionVP,
+		"precision-vp", StartParams.PrecisionVP, "Output precision of parameter space vertices, see -precision-v.")
+	flag.BoolVar(&StartParams.Float32,
+		"float32", StartParams.Float32, "Write the shortest representation that round trips as float32. Removes noise like 0.30000001192092896 from float32 origin values.")
+	flag.Float64Var(&StartParams.QuantizeV,
+		"quantize-v", StartParams.QuantizeV, "Snap vertex positions to a grid of this size before merging duplicates. <=0 disables.")
+	flag.Float64Var(&StartParams.QuantizeVT,
+		"quantize-vt", StartParams.QuantizeVT, "Snap UVs to a grid of this size before merging duplicates. <=0 disables.")
+	flag.StringVar(&StartParams.Header,
+		"header", StartParams.Header, "Custom header comment for the output file. Defaults to the tool name, version and processing time.")
+	flag.BoolVar(&StartParams.NoHeader,
+		"no-header", StartParams.NoHeader, "Omit the header comment from the output file.")
+	flag.BoolVar(&StartParams.Deterministic,
+		"deterministic", StartParams.Deterministic, "Produce byte identical output for the same input and options. Omits the processing time from the header comment.")
+	flag.BoolVar(&StartParams.Compact,
+		"compact", StartParams.Compact, "Write the smallest output: no comments or empty lines, usemtl and s only written when they change.")
+	flag.BoolVar(&StartParams.JsonBin,
+		"json-bin", StartParams.JsonBin, "With .json output write the three.js typed arrays to a .bin file next to it.")
 
 	flag.BoolVar(&StartParams.Strict,
 		"strict", StartParams.Strict, "Errors out on spec violations, otherwise continues if the error is recoverable.")
 	flag.BoolVar(&StartParams.Stdout,
 		"stdout", StartParams.Stdout, "Write output to stdout. If enabled -out is ignored and logging directed to stderr. Use -quiet if you can't separate stdout from stderr (e.g. non-trivial in Windows).")
+	flag.BoolVar(&StartParams.Analyze,
+		"analyze", StartParams.Analyze, "Print a report of the input file and what processing would achieve. No output file is written, -out is ignored.")
+	flag.BoolVar(&StartParams.Verify,
+		"verify", StartParams.Verify, "Compare the output to the input after writing it and fail if the surface, UV or normal deviation exceeds the -verify-xxx thresholds.")
+	registerOptions(flag.CommandLine, &StartParams.VerifyOptions)
 	flag.BoolVar(&StartParams.Quiet,
 		"quiet", StartParams.Quiet, "Silence stdout printing.")
 	flag.BoolVar(&StartParams.NoProgress,
-		"no-progress", StartParams.NoProgress, "No shell progress bars.")
+		"no-progress", StartParams.NoProgress, "No shell progress bars, same as -progress none.")
+	flag.StringVar(&StartParams.Progress,
+		"progress", StartParams.Progress, "Processor progress output: bar for shell progress bars, json for JSON events on stderr or none.")
 	flag.BoolVar(&StartParams.CpuProfile,
 		"cpu-profile", StartParams.CpuProfile, "Record ./cpu.pprof profile.")
-	flag.BoolVar(&version,
+	flag.BoolVar(&StartParams.Watch,
+		"watch", StartParams.Watch, "Poll the -in file or directory for changes and reprocess changed models until interrupted. With a directory -in, -out is the output directory.")
+	flag.DurationVar(&StartParams.WatchInterval,
+		"watch-interval", StartParams.WatchInterval, "How often -watch polls for changes.")
+	flag.DurationVar(&StartParams.WatchDebounce,
+		"watch-debounce", StartParams.WatchDebounce, "How long a file must be unchanged before -watch processes it. Avoids reading partially written files.")
+	flag.BoolVar(&showVersion,
 		"version", false, "Print version and exit, ignores -quiet.")
 
-	// -no-xxx to disable post processors
+	// -no-xxx to disable post processors and the processor options
 	for _, processor := range Processors {
 		flag.BoolVar(&processor.Disabled, processor.NameCmd(), processor.Disabled, processor.Desc())
+		if cp, ok := processor.Processor.(ContextProcessor); ok {
+			registerOptions(flag.CommandLine, cp.Options())
+		}
 	}
 
+	flag.Usage = usage
+}
+
+func parseStartParams() {
 	flag.Parse()
 
 	initLogging(!StartParams.Stdout)
 
 	// -version: ignores -stdout as we are about to exit
-	if version {
+	if showVersion {
 		fmt.Printf("%s %s\n", ApplicationName, getVersion(true))
 		os.Exit(0)
 	}
 
-	if StartParams.Workers < 1 {
-		logFatal("-workers must be a positive number, given: %d", StartParams.Workers)
+	// -config and -preset, explicit flags take precedence
+	if len(StartParams.Config) > 0 || len(StartParams.Preset) > 0 {
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = true
+		})
+		logFatalError(applyConfig(StartParams.Config, StartParams.Preset, explicit))
 	}
 
-	// -gzip
-	if StartParams.Gzip < -1 || StartParams.Gzip > gzip.BestCompression {
-		logFatal("-gzip must be -1 to 9, given: %d", StartParams.Gzip)
-	}
+	logFatalError(checkOptions())
 
-	// -in
-	StartParams.Input = cleanPath(StartParams.Input)
+	// -in: "-" reads stdin
+	if StartParams.Input != stdinPath {
+		StartParams.Input = cleanPath(StartParams.Input)
+	}
 	if len(StartParams.Input) == 0 {
 		logFatal("-in missing")
-	} else if !fileExists(StartParams.Input) {
+	} else if StartParams.Input != stdinPath && !fileExists(StartParams.Input) {
 		logFatal("-in file %q does not exist", StartParams.Input)
 	}
 
+	// -watch
+	inputIsDir := StartParams.Input != stdinPath && isDir(StartParams.Input)
+	if StartParams.Watch {
+		if StartParams.Input == stdinPath || StartParams.Stdout || StartParams.Analyze {
+			logFatal("-watch can't be used with stdin, -stdout or -analyze")
+		}
+		if StartParams.WatchInterval <= 0 || StartParams.WatchDebounce < 0 {
+			logFatal("-watch-interval must be positive and -watch-debounce not negative")
+		}
+	} else if inputIsDir {
+		logFatal("-in %q is a directory, directories are only supported with -watch", StartParams.Input)
+	}
+
 	// -out
-	if !StartParams.Stdout {
+	if !StartParams.Stdout && !StartParams.Analyze {
 		if len(StartParams.Output) > 0 {
 			StartParams.Output = cleanPath(StartParams.Output)
-		} else {
-			if iExt := strings.LastIndex(StartParams.Input, "."); iExt != -1 {
-				StartParams.Output = StartParams.Input[0:iExt] + ".simplified" + StartParams.Input[iExt:]
-			} else {
-				StartParams.Output = StartParams.Input + ".simplified"
-			}
+		} else if StartParams.Input == stdinPath {
+			logFatal("-out or -stdout is required when reading from stdin")
+		} else if !inputIsDir {
+			StartParams.Output = defaultOutputPath(StartParams.Input)
 		}
 		// don't allow user to overwrite source file, this app can be destructive and should
 		// not overwrite the source files. If user really wants to do this, he can rename the output file.
 		if StartParams.Input == StartParams.Output {
 			logFatal("Overwriting input file is not allowed, both input and output point to %s\n", StartParams.Input)
 		}
+		// -watch with a directory: -out is the output directory
+		if inputIsDir && len(StartParams.Output) > 0 {
+			logFatalError(os.MkdirAll(StartParams.Output, 0755))
+		}
 	}
+
+	// -json-bin
+	if StartParams.JsonBin && (StartParams.Stdout || StartParams.OutputEncoder().Name() != ThreejsEncoder{}.Name()) {
+		logFatal("-json-bin requires threejs -out file output")
+	}
+
+	// -report
+	StartParams.Report = cleanPath(StartParams.Report)
+}
+
+// Returns the output path when -out is not set. model.obj.gz and model.stl
+// are written to model.simplified.obj, -format ply to model.simplified.ply.
+func defaultOutputPath(input string) string {
+	input = trimCompressionExtension(input)
+	iExt := strings.LastIndex(input, ".")
+	if iExt == -1 || iExt < strings.LastIndex(input, "/") {
+		return input + ".simplified"
+	}
+	ext := input[iExt:]
+	if len(StartParams.Format) > 0 {
+		ext = StartParams.OutputEncoder().Extensions()[0]
+	} else if e := fileExtension(ext); e == ".stl" || e == ".ply" {
+		ext = ".obj"
+	}
+	return input[0:iExt] + ".simplified" + ext
+}
+
+// Validates the processing and output options that are not tied to files. Also used
+// by commands that set StartParams from other sources than the command line.
+func checkOptions() error {
+	if StartParams.Workers < 1 {
+		return fmt.Errorf("-workers must be a positive number, given: %d", StartParams.Workers)
+	}
+
+	// processor options
+	for _, processor := range Processors {
+		if cp, ok := processor.Processor.(ContextProcessor); ok {
+			if validator, ok := cp.Options().(optionsValidator); ok {
+				if err := validator.Validate(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// -verify-xxx
+	if err := StartParams.VerifyOptions.Validate(); err != nil {
+		return err
+	}
+
+	// -progress
+	if !containsString(progressModes, StartParams.Progress) {
+		return fmt.Errorf("-progress must be one of: %s, given: %q", strings.Join(progressModes, ", "), StartParams.Progress)
+	}
+
+	// -precision-xx
+	for _, precision := range []string{StartParams.PrecisionV, StartParams.PrecisionVN, StartParams.PrecisionVT, StartParams.PrecisionVP} {
+		if _, err := parseFloatFormat(precision); err != nil {
+			return err
+		}
+	}
+
+	// -pipeline
+	pipeline, err := parsePipeline(StartParams.Pipeline)
+	if err != nil {
+		return err
+	}
+	Pipeline = pipeline
+
+	// -quantize-xx: Quantize is a no-op without a grid
+	if StartParams.QuantizeV <= 0 && StartParams.QuantizeVT <= 0 {
+		for _, processor := range Processors {
+			if _, isQuantize := processor.Processor.(Quantize); isQuantize {
+				processor.Disabled = true
+			}
+		}
+	}
+	if err := validatePipeline(Pipeline); err != nil {
+		return err
+	}
+
+	// -gzip
+	if StartParams.Gzip < -1 || StartParams.Gzip > gzip.BestCompression {
+		return fmt.Errorf("-gzip must be -1 to 9, given: %d", StartParams.Gzip)
+	}
+
+	// -format
+	if len(StartParams.Format) > 0 && findEncoder(StartParams.Format) == nil {
+		return fmt.Errorf("-format %q is not supported, use one of: %s", StartParams.Format, strings.Join(encoderNames(), ", "))
+	}
+	return nil
 }
 
 func getVersion(date bool) (version string) {
@@ -161,7 +439,39 @@ type Processor interface {
 	Execute(obj *objectfile.OBJ) error
 }
 
+// ContextProcessor is run with Run instead of Execute. Its options
+// are registered as flags and config file options automatically.
+type ContextProcessor interface {
+	Processor
+	// Returns a pointer to the options struct, see registerOptions. nil if there are no options.
+	Options() interface{}
+	// Processes obj, the returned result is written to the -report.
+	// ctx is done when the run is cancelled or -timeout expires.
+	Run(ctx context.Context, obj *objectfile.OBJ, progress ProgressReporter) (interface{}, error)
+}
+
+// processorOptIn is implemented by processors that remove data users may want to
+// keep. They are not in the default pipeline and only run if listed in -pipeline.
+type processorOptIn interface {
+	OptIn() bool
+}
+
+// processorDependencies is implemented by processors that need others to run before them.
+type processorDependencies interface {
+	// Processors that must run earlier in the pipeline if they are in it and enabled.
+	RunsAfter() []string
+}
+
 func main() {
+	// commands eg. "obj-simplify validate model.obj"
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(cmd.Run(cmd, os.Args[2:]))
+		}
+	}
+
+	parseStartParams()
+
 	// cpu profiling for development: github.com/pkg/profile
 	if StartParams.CpuProfile {
 		defer profile.Start(profile.ProfilePath(".")).Stop()
@@ -172,83 +482,35 @@ func main() {
 	} else {
 		logFatalError(err)
 	}
-
-	type timing struct {
-		Step     string
-		Duration time.Duration
-	}
-
-	var (
-		start    = time.Now()
-		pre      = time.Now()
-		timings  = []timing{}
-		timeStep = func(step string) {
-			timings = append(timings, timing{Step: step, Duration: time.Now().Sub(pre)})
-			pre = time.Now()
-		}
-	)
-
-	// parse
-	obj, linesParsed, err := ParseFile(StartParams.Input)
-	if err != nil {
-		logFatalError(err)
-	}
-	timeStep("Parse")
-
-	// store stats before post-processing
-	preStats := obj.Stats()
-	// @todo this is ugly, maybe the face objects could be marked somehow.
-	// we want to show real stats, not faked object count stats at the end
-	preStats.Objects = ObjectsParsed
-	preStats.Groups = GroupsParsed
-
-	// post processing
-	for pi, processor := range Processors {
-		logInfo(" ")
-		if processor.Disabled {
-			logInfo("processor #%d: %s - Disabled", pi+1, processor.Name())
-			continue
+	if len(StartParams.Config) > 0 || len(StartParams.Preset) > 0 {
+		if b, err := json.MarshalIndent(effectiveConfig(), "", "  "); err == nil {
+			logInfo("\nconfig %s", b)
+		} else {
+			logFatalError(err)
 		}
-		logInfo("processor #%d: %s", pi+1, processor.Name())
-		logFatalError(processor.Execute(obj))
-		timeStep(processor.Name())
 	}
 
-	postStats := obj.Stats()
-
-	// write file out
-	var (
-		w            = &Writer{obj: obj}
-		linesWritten int
-		errWrite     error
-	)
-	if StartParams.Stdout {
-		linesWritten, errWrite = w.WriteTo(os.Stdout)
-	} else {
-		linesWritten, errWrite = w.WriteFile(StartParams.Output)
-	}
-	logFatalError(errWrite)
-	timeStep("Write")
-
-	// print stats etc
-	logInfo(" ")
-	durationTotal := time.Since(start)
-	for _, timing := range timings {
-		logResultsPostfix(timing.Step, formatDuration(timing.Duration), computeDurationPerc(timing.Duration, durationTotal)+"%%")
+	// -analyze: report and exit without processing
+	if StartParams.Analyze {
+		obj, _, _, err := parseInput(StartParams.Input)
+		logFatalError(err)
+		Analyze(obj)
+		return
 	}
-	logResults("Total", formatDuration(durationTotal))
 
-	logGeometryStats(preStats.Geometry, postStats.Geometry)
-	logVertexDataStats(preStats, postStats)
-	logObjectStats(preStats, postStats)
-	logFileStats(linesParsed, linesWritten)
+	// cancel processing on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	if StartParams.IsGzipEnabled() {
-		logInfo(" ")
-		logInfo("Gzip compression enabled with level %d.", StartParams.Gzip)
-		logInfo("Remeber to set 'Content-Encoding: gzip' header if you are hosting this file over HTTP.")
+	// -watch: rerun on changes until interrupted
+	if StartParams.Watch {
+		logFatalError(watch(ctx))
+		return
 	}
 
+	res, err := runPipeline(ctx)
+	logFatalError(err)
+	logRunStats(res)
 	logInfo(" ")
 }
 
@@ -296,7 +558,7 @@ func logVertexDataStats(stats, postprocessed objectfile.ObjStats) {
 	}
 }
 
-func logFileStats(linesParsed, linesWritten int) {
+func logFileStats(linesParsed, linesWritten int, sizeIn, sizeOut int64) {
 	logInfo(" ")
 	logResults("Lines input", formatInt(linesParsed))
 	if linesWritten < linesParsed {
@@ -306,7 +568,6 @@ func logFileStats(linesParsed, linesWritten int) {
 	}
 
 	logInfo(" ")
-	sizeIn, sizeOut := fileSize(StartParams.Input), fileSize(StartParams.Output)
 	logResults("File input", formatBytes(sizeIn))
 	if !StartParams.Stdout {
 		if sizeOut < sizeIn {
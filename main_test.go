@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	initLogging(true)
+	StartParams.Quiet = true
+	StartParams.NoProgress = true
+	os.Exit(m.Run())
+}
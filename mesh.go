@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// mesh is face geometry with a single index per corner, as required by formats
+// that do not index positions, uvs and normals separately. Each unique
+// v/vt/vn combination of the OBJ becomes one mesh vertex.
+type mesh struct {
+	Positions []*objectfile.GeometryValue
+	// Same length as Positions, entries are nil if the corner did not declare them.
+	UVs     []*objectfile.GeometryValue
+	Normals []*objectfile.GeometryValue
+
+	Faces []meshFace
+	// Material names in the order of first use, Faces index to this.
+	// Faces without a material use "".
+	Materials []string
+
+	HasUVs, HasNormals, HasColors bool
+	// Number of l and p declarations that were not added.
+	Skipped int
+
+	corners   map[meshCorner]int
+	materials map[string]int
+}
+
+type meshCorner struct {
+	vertex, uv, normal *objectfile.GeometryValue
+}
+
+type meshFace struct {
+	Indexes  []int
+	Material int
+}
+
+func newMesh() *mesh {
+	return &mesh{
+		corners:   make(map[meshCorner]int),
+		materials: make(map[string]int),
+	}
+}
+
+// Returns a mesh of the faces of all objects in obj.
+func buildMesh(obj *objectfile.OBJ) *mesh {
+	m := newMesh()
+	for _, child := range obj.Objects {
+		m.Add(child)
+	}
+	return m
+}
+
+func (m *mesh) Add(child *objectfile.Object) {
+	// materials are registered on the first face, lines and points don't use them
+	material := -1
+	for _, vd := range child.VertexData {
+		if vd.Type != objectfile.Face {
+			m.Skipped++
+			continue
+		}
+		if material == -1 {
+			material = m.material(child.Material)
+		}
+		face := meshFace{
+			Indexes:  make([]int, len(vd.Declarations)),
+			Material: material,
+		}
+		for i, decl := range vd.Declarations {
+			face.Indexes[i] = m.corner(meshCorner{decl.RefVertex, decl.RefUV, decl.RefNormal})
+		}
+		m.Faces = append(m.Faces, face)
+	}
+}
+
+func (m *mesh) corner(c meshCorner) int {
+	if index, ok := m.corners[c]; ok {
+		return index
+	}
+	index := len(m.Positions)
+	m.corners[c] = index
+	m.Positions = append(m.Positions, c.vertex)
+	m.UVs = append(m.UVs, c.uv)
+	m.Normals = append(m.Normals, c.normal)
+	m.HasUVs = m.HasUVs || c.uv != nil
+	m.HasNormals = m.HasNormals || c.normal != nil
+	m.HasColors = m.HasColors || c.vertex.Color != nil
+	return index
+}
+
+func (m *mesh) material(name string) int {
+	if index, ok := m.materials[name]; ok {
+		return index
+	}
+	index := len(m.Materials)
+	m.materials[name] = index
+	m.Materials = append(m.Materials, name)
+	return index
+}
+
+// True if any face declares a material.
+func (m *mesh) HasMaterials() bool {
+	return len(m.Materials) > 1 || (len(m.Materials) == 1 && len(m.Materials[0]) > 0)
+}
+
+// Number of triangles when all faces are triangulated.
+func (m *mesh) NumTriangles() (num int) {
+	return m.MaterialTriangles(-1)
+}
+
+// Number of triangles of the faces with material, all faces if material is -1.
+func (m *mesh) MaterialTriangles(material int) (num int) {
+	for _, face := range m.Faces {
+		if len(face.Indexes) >= 3 && (material == -1 || face.Material == material) {
+			num += len(face.Indexes) - 2
+		}
+	}
+	return num
+}
+
+// Returns the face as a triangle fan. OBJ faces are expected to be convex.
+func (f meshFace) Triangles() [][3]int {
+	if len(f.Indexes) < 3 {
+		return nil
+	}
+	triangles := make([][3]int, 0, len(f.Indexes)-2)
+	for i := 2; i < len(f.Indexes); i++ {
+		triangles = append(triangles, [3]int{f.Indexes[0], f.Indexes[i-1], f.Indexes[i]})
+	}
+	return triangles
+}
+
+// Value or the zero value if gv is nil.
+func meshValue(gv *objectfile.GeometryValue) objectfile.Vector {
+	if gv == nil {
+		return objectfile.Vector{}
+	}
+	return gv.Vector()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// Materials that only lines and points use are not mesh materials.
+func TestMeshMaterials(t *testing.T) {
+	obj, _, err := parse(bytes.NewReader([]byte("v 0 0 0\nv 1 0 0\nv 0 1 0\no wire\nusemtl wire\nl 1 2 3\no a\nusemtl red\nf 1 2 3\no b\nusemtl blue\nf 3 2 1\n")), "model.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := buildMesh(obj)
+	if len(m.Materials) != 2 || m.Materials[0] != "red" || m.Materials[1] != "blue" {
+		t.Fatalf("materials %q, want red and blue", m.Materials)
+	}
+	if m.Skipped != 1 {
+		t.Errorf("%d skipped, want 1", m.Skipped)
+	}
+
+	files, _, err := (StlEncoder{}).EncodeFiles(obj, filepath.Join(t.TempDir(), "model.stl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("wrote %q, want a file per face material", files)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+func ParseMtlFile(path string) ([]*objectfile.Material, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMtl(f, filepath.Base(path))
+}
+
+// Reads the mtllib files of obj to obj.Materials. Files are looked up from the
+// input archive first and then relative to dir. Missing files are skipped,
+// writers that need materials report the ones they can't find.
+func LoadMaterials(obj *objectfile.OBJ, in *inputFile, dir string) {
+	for _, value := range obj.MaterialLibraries {
+		for _, name := range strings.Fields(value) {
+			var (
+				materials []*objectfile.Material
+				err       error
+			)
+			if confineMtllibs {
+				name = filepath.Base(filepath.FromSlash(name))
+			}
+			if data, ok := in.Files[filepath.Base(name)]; ok {
+				materials, err = parseMtl(bytes.NewReader(data), name)
+			} else if path := filepath.Join(dir, name); fileExists(path) {
+				materials, err = ParseMtlFile(path)
+				// texture paths are relative to the mtl file
+				for _, m := range materials {
+					m.Mtllib = name
+				}
+			} else {
+				continue
+			}
+			if err != nil {
+				logWarn("Failed to read mtllib %s: %s", name, err)
+				continue
+			}
+			obj.Materials = append(obj.Materials, materials...)
+		}
+	}
+}
+
+// parseMtl reads newmtl declarations and their properties. Property values
+// are stored as is, only the structure of the file is validated.
+func parseMtl(src io.Reader, mtllib string) ([]*objectfile.Material, error) {
+	var (
+		materials = make([]*objectfile.Material, 0)
+		current   *objectfile.Material
+		scanner   = bufio.NewScanner(src)
+		linenum   = 0
+	)
+	for scanner.Scan() {
+		linenum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			key, value = line[0:i], strings.TrimSpace(line[i+1:])
+		}
+		if key == "newmtl" {
+			current = &objectfile.Material{
+				Mtllib: mtllib,
+				Name:   value,
+				Line:   linenum,
+			}
+			materials = append(materials, current)
+			continue
+		}
+		if current == nil {
+			return nil, wrapErrorLine(fmt.Errorf("%q declared before newmtl", key), linenum)
+		}
+		current.Properties = append(current.Properties, &objectfile.MaterialProperty{
+			Key:   key,
+			Value: value,
+			Line:  linenum,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"os"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -18,20 +17,44 @@ var (
 	GroupsParsed  int
 )
 
+// Parses path, see openInput for supported compression.
 func ParseFile(path string) (*objectfile.OBJ, int, error) {
-	f, err := os.Open(path)
+	in, err := openInput(path)
 	if err != nil {
 		return nil, -1, err
 	}
-	defer f.Close()
-	return parse(f)
+	defer in.Close()
+	return ParseInput(in)
+}
+
+// STL and PLY are detected from the extension or the data, stdin has no extension.
+func ParseInput(in *inputFile) (*objectfile.OBJ, int, error) {
+	r := bufio.NewReader(in.Reader)
+	magic, _ := r.Peek(5)
+	switch {
+	case fileExtension(in.Name) == ".ply" || bytes.HasPrefix(magic, []byte("ply")) && len(magic) > 3 && (magic[3] == '\n' || magic[3] == '\r'):
+		return parseConverted(parsePLY(r, in.Name))
+	case fileExtension(in.Name) == ".stl" || bytes.Equal(magic, []byte("solid")):
+		return parseConverted(parseSTL(r, in.Name))
+	}
+	return parse(r, in.Name)
+}
+
+// Formats without o/g declarations count the created objects as parsed.
+func parseConverted(obj *objectfile.OBJ, elements int, err error) (*objectfile.OBJ, int, error) {
+	if obj != nil {
+		ObjectsParsed += len(obj.Objects)
+	}
+	return obj, elements, err
 }
 
 func ParseBytes(b []byte) (*objectfile.OBJ, int, error) {
-	return parse(bytes.NewBuffer(b))
+	return parse(bytes.NewBuffer(b), StartParams.Input)
 }
 
-func parse(src io.Reader) (*objectfile.OBJ, int, error) {
+// name is the source file name, used to name the
+// default object if faces are declared without o/g.
+func parse(src io.Reader, name string) (*objectfile.OBJ, int, error) {
 	dest := objectfile.NewOBJ()
 	geom := dest.Geometry
 
@@ -53,7 +76,14 @@ func parse(src io.Reader) (*objectfile.OBJ, int, error) {
 		}
 		currentObjectChildIndex++
 		name := fmt.Sprintf("%s_%d", currentObjectName, currentObjectChildIndex)
-		return dest.CreateObject(ot, name, material)
+		child := dest.CreateObject(ot, name, material)
+		if currentObject != nil {
+			child.Origin = currentObject
+			if currentObject.Origin != nil {
+				child.Origin = currentObject.Origin
+			}
+		}
+		return child
 	}
 
 	for scanner.Scan() {
@@ -157,7 +187,7 @@ func parse(src io.Reader) (*objectfile.OBJ, int, error) {
 			// Our data structures and parsing however requires objects to put the faces into,
 			// create a default object that is named after the input file (without suffix).
 			if currentObject == nil {
-				currentObject = dest.CreateObject(objectfile.ChildObject, fileBasename(StartParams.Input), currentMaterial)
+				currentObject = dest.CreateObject(objectfile.ChildObject, fileBasename(name), currentMaterial)
 			}
 			vd, vdErr := currentObject.ReadVertexData(t, value, StartParams.Strict)
 			if vdErr != nil {
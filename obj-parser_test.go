@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+		"mtllib a.mtl\no a\nusemtl m1\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\nvn 0 0 1\nf 1/1/1 2/1/1 3/1/1\nusemtl m2\nf -3/-1/-1 -2/-1/-1 -1/-1/-1\n",
+		"g a\ns 1\nv 0 0 0 1 0 0\nv 1 1 1\nl 1 2\np 1\n",
+		"v 1 2\nf 1 1 1\n",
+		"f 1 2 3\n",
+		"v 0 0 0\nf 1//\n",
+		"# comment\nvp 0.5\n",
+	} {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, strict bool) {
+		defer func(s bool) { StartParams.Strict = s }(StartParams.Strict)
+		StartParams.Strict = strict
+
+		obj, _, err := parse(bytes.NewReader(data), "fuzz.obj")
+		if err != nil {
+			return
+		}
+		checkReferences(t, obj)
+		if _, err := (ObjEncoder{}).Encode(io.Discard, obj, ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Fails if a declaration references geometry that is not in obj.
+func checkReferences(t *testing.T, obj *objectfile.OBJ) {
+	t.Helper()
+	check := func(child *objectfile.Object, gt objectfile.Type, index int, ref *objectfile.GeometryValue) {
+		if index == 0 && ref == nil {
+			return
+		}
+		values := obj.Geometry.Get(gt)
+		if ref == nil || index < 1 || index > len(values) || values[index-1] != ref {
+			t.Fatalf("object %q: %s index %d out of bounds or not referenced, %d declared", child.Name, gt, index, len(values))
+		}
+	}
+	for _, child := range obj.Objects {
+		for _, vd := range child.VertexData {
+			for _, decl := range vd.Declarations {
+				check(child, objectfile.Vertex, decl.Index(objectfile.Vertex), decl.RefVertex)
+				check(child, objectfile.UV, decl.Index(objectfile.UV), decl.RefUV)
+				check(child, objectfile.Normal, decl.Index(objectfile.Normal), decl.RefNormal)
+			}
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, size := range []struct {
+		name     string
+		vertices int
+	}{{"1k", 1000}, {"10k", 10000}, {"100k", 100000}} {
+		b.Run(size.name, func(b *testing.B) {
+			data := generateForBenchmark(b, size.vertices)
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := parse(bytes.NewReader(data), "generated.obj"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
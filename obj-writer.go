@@ -5,46 +5,43 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jonnenauha/obj-simplify/objectfile"
 )
 
-type Writer struct {
-	obj *objectfile.OBJ
+type ObjEncoder struct{}
+
+func (enc ObjEncoder) Name() string {
+	return "obj"
 }
 
-func (wr *Writer) WriteFile(path string) (int, error) {
-	if fileExists(path) {
-		if err := os.Remove(path); err != nil {
-			return 0, err
-		}
-	}
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.ModePerm)
-	if err != nil {
-		return 0, err
-	}
-	linesWritten, errWrite := wr.WriteTo(f)
-	if cErr := f.Close(); cErr != nil && errWrite == nil {
-		errWrite = cErr
-	}
-	return linesWritten, errWrite
+func (enc ObjEncoder) Desc() string {
+	return "Wavefront OBJ"
 }
 
-func (wr *Writer) WriteTo(writer io.Writer) (int, error) {
-	linesWritten := 0
+func (enc ObjEncoder) Extensions() []string {
+	return []string{".obj"}
+}
 
-	w := writer
-	if StartParams.IsGzipEnabled() {
-		wGzip, errGzip := gzip.NewWriterLevel(writer, StartParams.Gzip)
-		if errGzip != nil {
-			return linesWritten, errGzip
-		}
-		defer wGzip.Close()
-		w = wGzip
+func (enc ObjEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	var (
+		linesWritten = 0
+		// -compact: no cosmetic lines or comments, no redundant state changes
+		compact = StartParams.Compact
+	)
+
+	w, errGzip := compressWriter(writer)
+	if errGzip != nil {
+		return linesWritten, errGzip
 	}
+	defer w.Close()
 
 	ln := func() {
+		if compact {
+			return
+		}
 		fmt.Fprint(w, "\n")
 		linesWritten++
 	}
@@ -64,7 +61,7 @@ func (wr *Writer) WriteTo(writer io.Writer) (int, error) {
 		}
 	}
 	writeComments := func(t objectfile.Type, values []string, newline bool) {
-		if len(values) == 0 {
+		if len(values) == 0 || compact {
 			return
 		}
 		comments := make([]string, len(values))
@@ -80,10 +77,10 @@ func (wr *Writer) WriteTo(writer io.Writer) (int, error) {
 		writeLines(t, comments, newline)
 	}
 
-	obj := wr.obj
-
 	// leave a comment that signifies this tool was ran on the file
-	writeLine(objectfile.Comment, fmt.Sprintf("Processed with %s %s | %s | %s", ApplicationName, getVersion(false), time.Now().UTC().Format(time.RFC3339), ApplicationURL), true)
+	if !StartParams.NoHeader {
+		writeComments(objectfile.Comment, strings.Split(headerComment(), "\n"), true)
+	}
 
 	// comments
 	writeComments(objectfile.Comment, obj.Comments, true)
@@ -97,29 +94,45 @@ func (wr *Writer) WriteTo(writer io.Writer) (int, error) {
 			if ti > 0 {
 				ln()
 			}
-			writeLine(objectfile.Comment, fmt.Sprintf("%s [%d]", t.Name(), len(slice)), true)
+			writeComments(objectfile.Comment, []string{fmt.Sprintf("%s [%d]", t.Name(), len(slice))}, true)
+			ff := StartParams.FloatFormatFor(t)
 			for _, value := range slice {
-				writeLine(t, value.String(t), false)
+				writeLine(t, value.Format(t, ff), false)
 			}
 		}
 	}
 	ln()
 
 	// objects: preserves the parsing order of g/o
-	writeLine(objectfile.Comment, fmt.Sprintf("objects [%d]", len(obj.Objects)), true)
+	writeComments(objectfile.Comment, []string{fmt.Sprintf("objects [%d]", len(obj.Objects))}, true)
+	// material and smoothing group are state that carries over to the next o/g
+	var lastMaterial, lastSmoothingGroup string
 	for _, child := range obj.Objects {
 		writeComments(objectfile.Comment, child.Comments, true)
 		writeLine(child.Type, child.Name, false)
 		// we dont skip writing material if it has already been declared as the
 		// last material, the file is easier to read for humans with write on each
 		// child, and this wont take many bytes in the total file size.
-		if len(child.Material) > 0 {
+		// -compact skips it.
+		if len(child.Material) > 0 && (!compact || child.Material != lastMaterial) {
 			writeLine(objectfile.MtlUse, child.Material, false)
+			lastMaterial = child.Material
 		}
 		ln()
 		for _, vd := range child.VertexData {
 			if sgroup := vd.Meta(objectfile.SmoothingGroup); len(sgroup) > 0 {
-				writeLine(objectfile.SmoothingGroup, sgroup, false)
+				if compact {
+					// "off" and "0" are the same, prefer the shorter one
+					if sgroup == "off" {
+						sgroup = "0"
+					}
+					if sgroup != lastSmoothingGroup {
+						writeLine(objectfile.SmoothingGroup, sgroup, false)
+					}
+				} else {
+					writeLine(objectfile.SmoothingGroup, sgroup, false)
+				}
+				lastSmoothingGroup = sgroup
 			}
 			writeLine(vd.Type, vd.String(), false)
 		}
@@ -128,3 +141,49 @@ func (wr *Writer) WriteTo(writer io.Writer) (int, error) {
 
 	return linesWritten, nil
 }
+
+// Replaces path with the output of writeTo.
+func writeFile(path string, writeTo func(io.Writer) (int, error)) (int, error) {
+	if fileExists(path) {
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	written, errWrite := writeTo(f)
+	if cErr := f.Close(); cErr != nil && errWrite == nil {
+		errWrite = cErr
+	}
+	return written, errWrite
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Returns w wrapped in a gzip writer if -gzip is enabled.
+// Close must be called to flush the compressed data.
+func compressWriter(w io.Writer) (io.WriteCloser, error) {
+	if !StartParams.IsGzipEnabled() {
+		return nopWriteCloser{w}, nil
+	}
+	return gzip.NewWriterLevel(w, StartParams.Gzip)
+}
+
+func headerComment() string {
+	if len(StartParams.Header) > 0 {
+		return StartParams.Header
+	}
+	// -deterministic: same input and options must produce the same bytes
+	if StartParams.Deterministic {
+		return fmt.Sprintf("Processed with %s %s | %s", ApplicationName, getVersion(false), ApplicationURL)
+	}
+	return fmt.Sprintf("Processed with %s %s | %s | %s", ApplicationName, getVersion(false), time.Now().UTC().Format(time.RFC3339), ApplicationURL)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeaderComment(t *testing.T) {
+	defer func(sp startParams) { StartParams = sp }(StartParams)
+	obj, _, err := parse(bytes.NewReader([]byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n")), "header.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func() string {
+		var out bytes.Buffer
+		if _, err := (ObjEncoder{}).Encode(&out, obj, ""); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	StartParams.Deterministic = true
+	if out := encode(); strings.Contains(out, time.Now().UTC().Format("2006-01-02")) || !strings.HasPrefix(out, "# Processed with") {
+		t.Errorf("-deterministic header:\n%s", out)
+	}
+	StartParams.Header = "line 1\nline 2"
+	if out := encode(); !strings.HasPrefix(out, "# line 1\n# line 2\n") {
+		t.Errorf("-header:\n%s", out)
+	}
+	StartParams.NoHeader = true
+	if out := encode(); !strings.HasPrefix(out, "# vertices") {
+		t.Errorf("-no-header:\n%s", out)
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	data := generateForBenchmark(b, 100000)
+	obj, _, err := parse(bytes.NewReader(data), "generated.obj")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := (ObjEncoder{}).Encode(io.Discard, obj, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
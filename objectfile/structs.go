@@ -144,6 +144,9 @@ type ObjStats struct {
 type OBJ struct {
 	Geometry          *Geometry
 	MaterialLibraries []string
+	// Materials read from MaterialLibraries. The OBJ parser does not
+	// read .mtl files, this is populated by the caller if needed.
+	Materials []*Material
 
 	Objects  []*Object
 	Comments []string
@@ -165,6 +168,16 @@ func (o *OBJ) ObjectWithType(t Type) (objects []*Object) {
 	return objects
 }
 
+// Returns the first material declared with name, nil if not found.
+func (o *OBJ) Material(name string) *Material {
+	for _, m := range o.Materials {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
 func (o *OBJ) CreateObject(t Type, name, material string) *Object {
 	if t != ChildObject && t != ChildGroup {
 		fmt.Printf("CreateObject: invalid object type %s", t)
@@ -215,6 +228,10 @@ type Object struct {
 	VertexData []*VertexData
 	Comments   []string
 
+	// Set for objects that the parser split off a multi-material o/g
+	// declaration, points to the object that was declared in the source.
+	Origin *Object
+
 	parent *OBJ
 }
 
@@ -294,6 +311,25 @@ func (o *Object) ReadVertexData(t Type, value string, strict bool) (*VertexData,
 
 // Declaration
 
+// Returns a declaration that references the given geometry values, any can be nil.
+func NewDeclaration(vertex, uv, normal *GeometryValue) *Declaration {
+	decl := &Declaration{
+		RefVertex: vertex,
+		RefUV:     uv,
+		RefNormal: normal,
+	}
+	if vertex != nil {
+		decl.Vertex = vertex.Index
+	}
+	if uv != nil {
+		decl.UV = uv.Index
+	}
+	if normal != nil {
+		decl.Normal = normal.Index
+	}
+	return decl
+}
+
 // zero value means it was not declared, should not be written
 // @note exception: if sibling declares it, must be written eg. 1//2
 type Declaration struct {
@@ -439,8 +475,10 @@ func (f *VertexData) Meta(t Type) string {
 	return ""
 }
 
+// Returns the declaration at index, growing the declarations as needed.
+// Faces can be n-gons, there is no upper limit.
 func (f *VertexData) Index(index int) *Declaration {
-	if index >= 0 && index <= 3 {
+	if index >= 0 {
 		for index >= len(f.Declarations) {
 			f.Declarations = append(f.Declarations, &Declaration{})
 		}
@@ -535,10 +573,12 @@ func (g *Geometry) ReadValue(t Type, value string, strict bool) (*GeometryValue,
 	if t == Vertex || t == Point {
 		gv.W = 1
 	}
-	for i, part := range strings.Split(value, " ") {
-		if len(part) == 0 {
-			continue
-		}
+	parts := strings.Fields(value)
+	// "v x y z r g b" is a common vertex color extension to the spec
+	if t == Vertex && len(parts) == 6 {
+		gv.Color = &Color{}
+	}
+	for i, part := range parts {
 		if part == "-0" {
 			part = "0"
 		} else if strings.Index(part, "-0.") == 0 {
@@ -559,7 +599,17 @@ func (g *Geometry) ReadValue(t Type, value string, strict bool) (*GeometryValue,
 			gv.Y = num
 		case 2:
 			gv.Z = num
-		case 3:
+		case 3, 4, 5:
+			if gv.Color != nil {
+				gv.Color.Set(i-3, num)
+				break
+			}
+			if i > 3 {
+				if strict {
+					return nil, fmt.Errorf("Found invalid fifth component: %s %s", t.String(), value)
+				}
+				break
+			}
 			if strict && t != Vertex {
 				return nil, fmt.Errorf("Found invalid fourth component: %s %s", t.String(), value)
 			}
@@ -571,6 +621,12 @@ func (g *Geometry) ReadValue(t Type, value string, strict bool) (*GeometryValue,
 			break
 		}
 	}
+	return g.Append(t, gv)
+}
+
+// Appends gv and sets its index. Used by parsers of other formats
+// that produce geometry values directly.
+func (g *Geometry) Append(t Type, gv *GeometryValue) (*GeometryValue, error) {
 	// OBJ refs start from 1 not zero
 	gv.Index = len(g.Get(t)) + 1
 	switch t {
@@ -655,25 +711,95 @@ type GeometryValue struct {
 	Index      int
 	Discard    bool
 	X, Y, Z, W float64
+
+	// Vertex color, nil if not declared.
+	Color *Color
+}
+
+// Color
+
+type Color struct {
+	R, G, B float64
+}
+
+func (c *Color) Set(i int, value float64) {
+	switch i {
+	case 0:
+		c.R = value
+	case 1:
+		c.G = value
+	case 2:
+		c.B = value
+	}
+}
+
+func (c *Color) Equals(other *Color, epsilon float64) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	return math.Abs(c.R-other.R) <= epsilon &&
+		math.Abs(c.G-other.G) <= epsilon &&
+		math.Abs(c.B-other.B) <= epsilon
 }
 
 func equals(a, b, epsilon float64) bool {
 	return (math.Abs(a-b) <= epsilon)
 }
 
-func (gv *GeometryValue) String(t Type) (out string) {
+func (gv *GeometryValue) String(t Type) string {
+	return gv.Format(t, DefaultFloatFormat)
+}
+
+func (gv *GeometryValue) Format(t Type, ff FloatFormat) (out string) {
 	switch t {
 	case UV:
-		out = strconv.FormatFloat(gv.X, 'g', -1, 64) + " " + strconv.FormatFloat(gv.Y, 'g', -1, 64)
+		out = ff.Format(gv.X) + " " + ff.Format(gv.Y)
 	default:
-		out = strconv.FormatFloat(gv.X, 'g', -1, 64) + " " + strconv.FormatFloat(gv.Y, 'g', -1, 64) + " " + strconv.FormatFloat(gv.Z, 'g', -1, 64)
+		out = ff.Format(gv.X) + " " + ff.Format(gv.Y) + " " + ff.Format(gv.Z)
 	}
 	// omit default values
 	switch t {
 	case Vertex, Point:
-		if !equals(gv.W, 1, 1e-10) {
-			out += " " + strconv.FormatFloat(gv.W, 'g', -1, 64)
+		if gv.Color != nil {
+			// colors replace w in the extension
+			out += " " + ff.Format(gv.Color.R) + " " + ff.Format(gv.Color.G) + " " + ff.Format(gv.Color.B)
+		} else if !equals(gv.W, 1, 1e-10) {
+			out += " " + ff.Format(gv.W)
+		}
+	}
+	return out
+}
+
+// FloatFormat
+
+// Controls how geometry values are serialized. Decimals takes precedence
+// over Significant, the zero value writes the shortest representation.
+type FloatFormat struct {
+	Decimals    int  // fixed number of decimals if >0, trailing zeros are trimmed
+	Significant int  // number of significant digits if >0
+	Float32     bool // shortest representation that round trips as float32
+}
+
+var DefaultFloatFormat = FloatFormat{}
+
+func (ff FloatFormat) Format(f float64) (out string) {
+	bitSize := 64
+	if ff.Float32 {
+		bitSize = 32
+	}
+	if ff.Decimals > 0 {
+		out = strconv.FormatFloat(f, 'f', ff.Decimals, bitSize)
+		if strings.Contains(out, ".") {
+			out = strings.TrimRight(strings.TrimRight(out, "0"), ".")
 		}
+	} else if ff.Significant > 0 {
+		out = strconv.FormatFloat(f, 'g', ff.Significant, bitSize)
+	} else {
+		out = strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+	// rounding can produce "-0"
+	if out == "-0" {
+		out = "0"
 	}
 	return out
 }
@@ -689,12 +815,25 @@ func (gv *GeometryValue) Equals(other *GeometryValue, epsilon float64) bool {
 	if math.Abs(gv.X-other.X) <= epsilon &&
 		math.Abs(gv.Y-other.Y) <= epsilon &&
 		math.Abs(gv.Z-other.Z) <= epsilon &&
-		math.Abs(gv.W-other.W) <= epsilon {
+		math.Abs(gv.W-other.W) <= epsilon &&
+		gv.Color.Equals(other.Color, epsilon) {
 		return true
 	}
 	return false
 }
 
+// Compares direction only, values are equal if the angle between them is
+// maxAngle radians or less. Zero length values only equal each other.
+func (gv *GeometryValue) EqualsAngle(other *GeometryValue, maxAngle float64) bool {
+	a, b := gv.Vector(), other.Vector()
+	la, lb := a.Length(), b.Length()
+	if la == 0 || lb == 0 {
+		return la == lb
+	}
+	cos := a.Dot(b) / (la * lb)
+	return cos >= math.Cos(maxAngle)
+}
+
 func NewGeometry() *Geometry {
 	return &Geometry{
 		Vertices: make([]*GeometryValue, 0),
@@ -706,7 +845,103 @@ func NewGeometry() *Geometry {
 
 // Material
 
+// http://paulbourke.net/dataformats/mtl/
 type Material struct {
 	Mtllib string
 	Name   string
+	Line   int // newmtl line in Mtllib
+
+	// Properties in declaration order eg. Kd, Ns and map_Kd.
+	Properties []*MaterialProperty
+}
+
+type MaterialProperty struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Returns the last declared property with key, keys are case insensitive.
+func (m *Material) Property(key string) *MaterialProperty {
+	for i := len(m.Properties) - 1; i >= 0; i-- {
+		if strings.EqualFold(m.Properties[i].Key, key) {
+			return m.Properties[i]
+		}
+	}
+	return nil
+}
+
+func (m *Material) Get(key string) string {
+	if p := m.Property(key); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// Returns the numeric components of key eg. "Kd 1 0.5 0" as [1, 0.5, 0].
+// Returns nil if key is not declared or the value is not numeric.
+func (m *Material) Floats(key string) []float64 {
+	p := m.Property(key)
+	if p == nil {
+		return nil
+	}
+	out := make([]float64, 0, 3)
+	for _, part := range strings.Fields(p.Value) {
+		num, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil
+		}
+		out = append(out, num)
+	}
+	return out
+}
+
+// Returns properties that reference texture files eg. map_Kd and bump.
+func (m *Material) TextureProperties() (textures []*MaterialProperty) {
+	for _, p := range m.Properties {
+		if IsTextureProperty(p.Key) {
+			textures = append(textures, p)
+		}
+	}
+	return textures
+}
+
+func IsTextureProperty(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "map_") || key == "bump" || key == "disp" || key == "decal" || key == "refl"
+}
+
+// Returns the file path of a texture property. Texture statements
+// can declare options before the path eg. "map_Kd -s 1 1 1 -clamp on tex.png".
+func (p *MaterialProperty) TexturePath() string {
+	parts := strings.Fields(p.Value)
+	if len(parts) == 0 {
+		return ""
+	}
+	// options and their arguments precede the path, paths may contain spaces
+	i := 0
+	for i < len(parts)-1 && strings.HasPrefix(parts[i], "-") {
+		i++
+		for arg, max := 0, textureOptionArgs(parts[i-1]); arg < max && i < len(parts)-1; arg++ {
+			// vector options take 1 to 3 numbers
+			if _, err := strconv.ParseFloat(parts[i], 64); err != nil && max > 1 {
+				break
+			}
+			i++
+		}
+	}
+	return strings.Join(parts[i:], " ")
+}
+
+// Returns the maximum number of arguments a texture option takes.
+func textureOptionArgs(option string) int {
+	switch strings.ToLower(option) {
+	case "-o", "-s", "-t":
+		return 3
+	case "-mm":
+		return 2
+	case "-blendu", "-blendv", "-boost", "-cc", "-clamp", "-texres", "-bm", "-imfchan", "-type":
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,110 @@
+package objectfile
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func FuzzParseFaceVertexData(f *testing.F) {
+	for _, seed := range []string{"1 2 3", "1/1 2/2 3/3", "1//1 2//2 3//3", "1/1/1 2/2/2 3/3/3 4/4/4", "-3/-3/-3 -2/-2/-2 -1/-1/-1", "1/2/3/4 5", "", "a/b/c", "1  2"} {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, str string, strict bool) {
+		vt, err := ParseFaceVertexData(str, strict)
+		if err != nil {
+			return
+		}
+		if vt.Type != Face {
+			t.Fatalf("type %s, want f", vt.Type)
+		}
+		if parts := strings.Split(str, " "); len(vt.Declarations) != len(parts) {
+			t.Fatalf("%q: %d declarations for %d parts", str, len(vt.Declarations), len(parts))
+		}
+		for i, decl := range vt.Declarations {
+			if decl == nil {
+				t.Fatalf("%q: declaration %d is nil", str, i)
+			}
+		}
+	})
+}
+
+func FuzzParseListVertexData(f *testing.F) {
+	for _, seed := range []string{"1 2", "1/1 2/2", "1 2 3 4", "-1 -2", "1/2/3", "", "x"} {
+		f.Add(seed, false, false)
+		f.Add(seed, true, true)
+	}
+	f.Fuzz(func(t *testing.T, str string, point, strict bool) {
+		typ := Line
+		if point {
+			typ = Point
+		}
+		vt, err := ParseListVertexData(typ, str, strict)
+		if err != nil {
+			return
+		}
+		if vt.Type != typ {
+			t.Fatalf("type %s, want %s", vt.Type, typ)
+		}
+		if parts := strings.Split(str, " "); len(vt.Declarations) != len(parts) {
+			t.Fatalf("%q: %d declarations for %d parts", str, len(vt.Declarations), len(parts))
+		}
+		for _, decl := range vt.Declarations {
+			if decl.Normal != 0 {
+				t.Fatalf("%q: lines and points can't declare normals", str)
+			}
+		}
+	})
+}
+
+// Values that are read and written again must read back the same.
+func FuzzReadValue(f *testing.F) {
+	for _, seed := range []string{"1 2 3", "1 2 3 0.5", "1 2 3 1 0 0", "0.5 0.25", "-0 -0.000 1e-7", "1e308 -1e308 0", "1 2 3 4 5", "", "x y z"} {
+		for _, t := range []Type{Vertex, Normal, UV, Param} {
+			f.Add(seed, int(t), false)
+		}
+	}
+	f.Fuzz(func(t *testing.T, value string, typ int, strict bool) {
+		gt := Type(typ)
+		if gt != Vertex && gt != Normal && gt != UV && gt != Param {
+			return
+		}
+		g := NewGeometry()
+		gv, err := g.ReadValue(gt, value, strict)
+		if err != nil {
+			return
+		}
+		if gv.Index != 1 || len(g.Get(gt)) != 1 {
+			t.Fatalf("index %d with %d values, want 1", gv.Index, len(g.Get(gt)))
+		}
+		components := []float64{gv.X, gv.Y, gv.Z, gv.W}
+		if gv.Color != nil {
+			components = append(components, gv.Color.R, gv.Color.G, gv.Color.B)
+		}
+		for _, c := range components {
+			if math.IsNaN(c) || math.IsInf(c, 0) {
+				return
+			}
+		}
+
+		out := gv.String(gt)
+		gv2, err := NewGeometry().ReadValue(gt, out, strict)
+		if err != nil {
+			t.Fatalf("%q written as %q: %s", value, out, err)
+		}
+		equal := gv.X == gv2.X && gv.Y == gv2.Y
+		switch gt {
+		case Vertex:
+			equal = equal && gv.Z == gv2.Z && gv.Color.Equals(gv2.Color, 0)
+			if gv.Color == nil {
+				equal = equal && math.Abs(gv.W-gv2.W) <= 1e-10
+			}
+		case Normal, Param:
+			equal = equal && gv.Z == gv2.Z
+		}
+		if !equal {
+			t.Fatalf("%q written as %q reads %#v, want %#v", value, out, gv2, gv)
+		}
+	})
+}
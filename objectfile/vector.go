@@ -0,0 +1,136 @@
+package objectfile
+
+import "math"
+
+// Vector
+
+type Vector struct {
+	X, Y, Z float64
+}
+
+func (v Vector) Add(o Vector) Vector {
+	return Vector{v.X + o.X, v.Y + o.Y, v.Z + o.Z}
+}
+
+func (v Vector) Sub(o Vector) Vector {
+	return Vector{v.X - o.X, v.Y - o.Y, v.Z - o.Z}
+}
+
+func (v Vector) Scale(s float64) Vector {
+	return Vector{v.X * s, v.Y * s, v.Z * s}
+}
+
+func (v Vector) Dot(o Vector) float64 {
+	return v.X*o.X + v.Y*o.Y + v.Z*o.Z
+}
+
+func (v Vector) Cross(o Vector) Vector {
+	return Vector{
+		v.Y*o.Z - v.Z*o.Y,
+		v.Z*o.X - v.X*o.Z,
+		v.X*o.Y - v.Y*o.X,
+	}
+}
+
+func (v Vector) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Returns a unit length vector, or the zero vector if v has zero length.
+func (v Vector) Normalize() Vector {
+	if l := v.Length(); l > 0 {
+		return v.Scale(1 / l)
+	}
+	return Vector{}
+}
+
+// BoundingBox
+
+type BoundingBox struct {
+	Min, Max Vector
+	Empty    bool
+}
+
+func NewBoundingBox() BoundingBox {
+	return BoundingBox{
+		Min:   Vector{math.Inf(1), math.Inf(1), math.Inf(1)},
+		Max:   Vector{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+		Empty: true,
+	}
+}
+
+func (bb *BoundingBox) Expand(v Vector) {
+	bb.Empty = false
+	bb.Min = Vector{math.Min(bb.Min.X, v.X), math.Min(bb.Min.Y, v.Y), math.Min(bb.Min.Z, v.Z)}
+	bb.Max = Vector{math.Max(bb.Max.X, v.X), math.Max(bb.Max.Y, v.Y), math.Max(bb.Max.Z, v.Z)}
+}
+
+func (bb BoundingBox) Size() Vector {
+	if bb.Empty {
+		return Vector{}
+	}
+	return bb.Max.Sub(bb.Min)
+}
+
+func (bb BoundingBox) Diagonal() float64 {
+	return bb.Size().Length()
+}
+
+// Bounding box of all declared vertices.
+func (g *Geometry) BoundingBox() BoundingBox {
+	bb := NewBoundingBox()
+	for _, gv := range g.Vertices {
+		bb.Expand(gv.Vector())
+	}
+	return bb
+}
+
+func (gv *GeometryValue) Vector() Vector {
+	return Vector{gv.X, gv.Y, gv.Z}
+}
+
+// Face geometry helpers. Declarations without a vertex ref are skipped.
+
+// Returns the vertex positions of the declarations in order.
+func (vt *VertexData) Positions() []Vector {
+	positions := make([]Vector, 0, len(vt.Declarations))
+	for _, decl := range vt.Declarations {
+		if decl.RefVertex != nil {
+			positions = append(positions, decl.RefVertex.Vector())
+		}
+	}
+	return positions
+}
+
+// Returns the non-normalized polygon normal using Newell's method.
+// Its length is twice the area of a planar polygon.
+func (vt *VertexData) AreaNormal() Vector {
+	var (
+		positions = vt.Positions()
+		n         Vector
+	)
+	for i := range positions {
+		cur, next := positions[i], positions[(i+1)%len(positions)]
+		n.X += (cur.Y - next.Y) * (cur.Z + next.Z)
+		n.Y += (cur.Z - next.Z) * (cur.X + next.X)
+		n.Z += (cur.X - next.X) * (cur.Y + next.Y)
+	}
+	return n
+}
+
+// Area of a face. Zero for lines and points.
+func (vt *VertexData) Area() float64 {
+	if vt.Type != Face {
+		return 0
+	}
+	return vt.AreaNormal().Length() * 0.5
+}
+
+// Returns the number of unique vertex indexes referenced.
+func (vt *VertexData) NumUniqueVertices() int {
+	seen := make(map[int]bool, len(vt.Declarations))
+	for _, decl := range vt.Declarations {
+		seen[decl.Index(Vertex)] = true
+	}
+	return len(seen)
+}
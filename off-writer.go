@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// OffEncoder writes ASCII OFF. OFF only has positions and faces, the face
+// color is the diffuse color of its material if the mtllib was found.
+type OffEncoder struct{}
+
+func (enc OffEncoder) Name() string {
+	return "off"
+}
+
+func (enc OffEncoder) Desc() string {
+	return "ASCII OFF with material diffuse colors as face colors"
+}
+
+func (enc OffEncoder) Extensions() []string {
+	return []string{".off"}
+}
+
+func (enc OffEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		bw       = bufio.NewWriter(wc)
+		lw       = &lineCountingWriter{w: bw}
+		ff       = StartParams.FloatFormatFor(objectfile.Vertex)
+		vertices = obj.Geometry.Vertices
+		faces    = 0
+		skipped  = 0
+		// material diffuse colors, nil if not declared
+		colors = make(map[string][]float64)
+	)
+	for _, child := range obj.Objects {
+		for _, vd := range child.VertexData {
+			if vd.Type == objectfile.Face {
+				faces++
+			} else {
+				skipped++
+			}
+		}
+		if _, ok := colors[child.Material]; !ok {
+			if material := obj.Material(child.Material); material != nil {
+				colors[child.Material] = material.Floats("Kd")
+			} else {
+				colors[child.Material] = nil
+			}
+		}
+	}
+	if skipped > 0 {
+		logWarn("OFF does not support lines and points, %d declarations not written", skipped)
+	}
+
+	fmt.Fprint(lw, "OFF\n")
+	if !StartParams.NoHeader && !StartParams.Compact {
+		for _, line := range strings.Split(headerComment(), "\n") {
+			fmt.Fprintf(lw, "# %s\n", line)
+		}
+	}
+	// the edge count is not used by readers
+	fmt.Fprintf(lw, "%d %d 0\n", len(vertices), faces)
+	for _, v := range vertices {
+		fmt.Fprintf(lw, "%s %s %s\n", ff.Format(v.X), ff.Format(v.Y), ff.Format(v.Z))
+	}
+	for _, child := range obj.Objects {
+		color := ""
+		if kd := colors[child.Material]; len(kd) >= 3 {
+			color = fmt.Sprintf(" %s %s %s", ff.Format(kd[0]), ff.Format(kd[1]), ff.Format(kd[2]))
+		}
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				continue
+			}
+			fmt.Fprintf(lw, "%d", len(vd.Declarations))
+			for _, decl := range vd.Declarations {
+				// OFF indexes start from 0
+				fmt.Fprintf(lw, " %d", decl.Index(objectfile.Vertex)-1)
+			}
+			fmt.Fprintf(lw, "%s\n", color)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return lw.lines, wc.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Processor options structs declare their flags with struct tags:
+//
+//	Epsilon float64 `flag:"epsilon" usage:"Epsilon for float comparisons."`
+//
+// The current field values are the flag defaults. Supported field types
+// are bool, int, float64, string and time.Duration.
+
+// optionsValidator is implemented by options structs that need validation after parsing.
+type optionsValidator interface {
+	Validate() error
+}
+
+type optionField struct {
+	Name  string
+	Usage string
+	Ptr   interface{}
+}
+
+// Returns the tagged fields of the options struct pointer.
+func optionFields(options interface{}) []optionField {
+	if options == nil {
+		return nil
+	}
+	v := reflect.ValueOf(options)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("processor options must be a struct pointer, got %T", options))
+	}
+	v = v.Elem()
+	fields := make([]optionField, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if name := field.Tag.Get("flag"); len(name) > 0 {
+			fields = append(fields, optionField{
+				Name:  name,
+				Usage: field.Tag.Get("usage"),
+				Ptr:   v.Field(i).Addr().Interface(),
+			})
+		}
+	}
+	return fields
+}
+
+// Registers the tagged fields of the options struct pointer to fs.
+func registerOptions(fs *flag.FlagSet, options interface{}) {
+	for _, field := range optionFields(options) {
+		switch ptr := field.Ptr.(type) {
+		case *bool:
+			fs.BoolVar(ptr, field.Name, *ptr, field.Usage)
+		case *int:
+			fs.IntVar(ptr, field.Name, *ptr, field.Usage)
+		case *float64:
+			fs.Float64Var(ptr, field.Name, *ptr, field.Usage)
+		case *string:
+			fs.StringVar(ptr, field.Name, *ptr, field.Usage)
+		case *time.Duration:
+			fs.DurationVar(ptr, field.Name, *ptr, field.Usage)
+		default:
+			panic(fmt.Sprintf("option %s has unsupported type %T", field.Name, field.Ptr))
+		}
+	}
+}
+
+// Copies the values of the options struct pointer and returns a function that restores them.
+func saveOptions(options interface{}) func() {
+	if options == nil {
+		return func() {}
+	}
+	v := reflect.ValueOf(options).Elem()
+	saved := reflect.New(v.Type()).Elem()
+	saved.Set(v)
+	return func() { v.Set(saved) }
+}
+
+// Returns the flag names of the options struct pointer.
+func optionNames(options interface{}) []string {
+	var names []string
+	for _, field := range optionFields(options) {
+		names = append(names, field.Name)
+	}
+	return names
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// runResult is the outcome of processing StartParams.Input to StartParams.Output.
+type runResult struct {
+	Input       string
+	Output      string
+	OutputFiles []string
+	// Material libraries declared by the input.
+	MaterialLibraries []string
+
+	Pre, Post    objectfile.ObjStats
+	LinesParsed  int
+	LinesWritten int
+	SizeIn       int64
+	SizeOut      int64
+
+	Timings  []runTiming
+	Duration time.Duration
+}
+
+type runTiming struct {
+	Step     string
+	Duration time.Duration
+}
+
+func processorNames() []string {
+	return pipelineNames(Processors)
+}
+
+func pipelineNames(pipeline []*processor) []string {
+	names := make([]string, 0, len(pipeline))
+	for _, p := range pipeline {
+		names = append(names, configName(p))
+	}
+	return names
+}
+
+// Returns the processors in the default order without the opt-in processors.
+func defaultPipeline() []*processor {
+	pipeline := make([]*processor, 0, len(Processors))
+	for _, p := range Processors {
+		if optIn, ok := p.Processor.(processorOptIn); ok && optIn.OptIn() {
+			continue
+		}
+		pipeline = append(pipeline, p)
+	}
+	return pipeline
+}
+
+// Parses the comma separated -pipeline, empty returns the default pipeline.
+// Plugins and opt-in processors are only run if listed.
+func parsePipeline(spec string) ([]*processor, error) {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return defaultPipeline(), nil
+	}
+	var pipeline []*processor
+	for _, name := range strings.Split(spec, ",") {
+		p := findProcessor(strings.TrimSpace(name))
+		if p == nil {
+			p = findPlugin(strings.TrimSpace(name))
+		}
+		if p == nil {
+			return nil, fmt.Errorf("-pipeline: unknown processor %q, use: %s", strings.TrimSpace(name), strings.Join(append(processorNames(), pluginNames()...), ", "))
+		}
+		pipeline = append(pipeline, p)
+	}
+	return pipeline, nil
+}
+
+// Checks that processors run after the enabled processors they depend on.
+func validatePipeline(pipeline []*processor) error {
+	enabled := make(map[string]bool)
+	for _, p := range pipeline {
+		enabled[configName(p)] = enabled[configName(p)] || !p.Disabled
+	}
+	ran := make(map[string]bool)
+	for _, p := range pipeline {
+		if p.Disabled {
+			continue
+		}
+		if deps, ok := p.Processor.(processorDependencies); ok {
+			for _, dep := range deps.RunsAfter() {
+				if enabled[dep] && !ran[dep] {
+					return fmt.Errorf("-pipeline: %s must run after %s", configName(p), dep)
+				}
+			}
+		}
+		ran[configName(p)] = true
+	}
+	return nil
+}
+
+// Parses path, its material libraries are loaded to obj.Materials.
+func parseInput(path string) (obj *objectfile.OBJ, input *inputFile, linesParsed int, err error) {
+	// counters are global, reset for each run
+	ObjectsParsed, GroupsParsed = 0, 0
+
+	input, err = openInput(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	obj, linesParsed, err = ParseInput(input)
+	if cErr := input.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return nil, nil, linesParsed, err
+	}
+	LoadMaterials(obj, input, filepath.Dir(path))
+	return obj, input, linesParsed, nil
+}
+
+// Parses, processes and writes StartParams.Input. The -report
+// file is written at the end of a successful run.
+func runPipeline(ctx context.Context) (*runResult, error) {
+	if StartParams.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, StartParams.Timeout)
+		defer cancel()
+	}
+	ctx = withWorkers(ctx, StartParams.Workers)
+	var (
+		start    = time.Now()
+		pre      = time.Now()
+		res      = &runResult{Input: StartParams.Input, Output: StartParams.Output}
+		timeStep = func(step string) {
+			res.Timings = append(res.Timings, runTiming{Step: step, Duration: time.Now().Sub(pre)})
+			Report.AddTiming(step, time.Now().Sub(pre))
+			pre = time.Now()
+		}
+	)
+
+	Report = newRunReport()
+	Report.Version = getVersion(false)
+	Report.Started = start.UTC().Format(time.RFC3339)
+	Report.Params = newReportParams()
+
+	// parse
+	obj, input, linesParsed, err := parseInput(StartParams.Input)
+	if err != nil {
+		return nil, err
+	}
+	res.LinesParsed = linesParsed
+	res.SizeIn = input.BytesRead()
+	res.MaterialLibraries = obj.MaterialLibraries
+	// -verify: processors modify obj
+	var before *surface
+	if StartParams.Verify {
+		before = newSurface(obj)
+	}
+	timeStep("Parse")
+
+	// store stats before post-processing
+	res.Pre = obj.Stats()
+	// @todo this is ugly, maybe the face objects could be marked somehow.
+	// we want to show real stats, not faked object count stats at the end
+	res.Pre.Objects = ObjectsParsed
+	res.Pre.Groups = GroupsParsed
+	Report.Before = newReportStats(res.Pre)
+
+	// post processing
+	for _, processor := range Processors {
+		Report.Processor(processor.Name()).Disabled = !isInPipeline(processor)
+	}
+	runs := make(map[*processor]int)
+	stats := newReportStats(obj.Stats())
+	for pi, processor := range Pipeline {
+		logInfo(" ")
+		if processor.Disabled {
+			logInfo("processor #%d: %s - Disabled", pi+1, processor.Name())
+			continue
+		}
+		logInfo("processor #%d: %s", pi+1, processor.Name())
+		// details of repeated runs are stored per step
+		details, err := processor.Run(ctx, obj)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s did not finish within -timeout %s: %w", processor.Name(), StartParams.Timeout, err)
+		} else if err != nil {
+			return nil, err
+		}
+		step := processor.Name()
+		if runs[processor]++; runs[processor] > 1 {
+			step = fmt.Sprintf("%s #%d", step, runs[processor])
+		}
+		after := newReportStats(obj.Stats())
+		Report.AddStep(reportStep{
+			Step:    step,
+			Seconds: time.Since(pre).Seconds(),
+			After:   after,
+			Diff:    after.Sub(stats),
+			Details: details,
+		})
+		stats = after
+		timeStep(step)
+	}
+
+	res.Post = obj.Stats()
+	Report.After = newReportStats(res.Post)
+
+	// write file out
+	encoder := StartParams.OutputEncoder()
+	if StartParams.Stdout {
+		res.LinesWritten, err = encoder.Encode(os.Stdout, obj, "")
+	} else {
+		res.OutputFiles, res.LinesWritten, err = encodeFile(encoder, obj, StartParams.Output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range res.OutputFiles {
+		res.SizeOut += fileSize(path)
+	}
+	if !StartParams.Stdout {
+		if err := input.WriteMaterialLibraries(filepath.Dir(StartParams.Output)); err != nil {
+			return nil, err
+		}
+	}
+	timeStep("Write")
+
+	// -verify: exceeded thresholds fail the run after the -report is written
+	if StartParams.Verify {
+		if Report.Verify, err = verifyOutput(ctx, before, obj, encoder); err != nil {
+			return nil, err
+		}
+		timeStep("Verify")
+	}
+	res.Duration = time.Since(start)
+
+	// -report
+	if len(StartParams.Report) > 0 {
+		Report.Files = reportFiles{
+			Input:       StartParams.Input,
+			InputBytes:  res.SizeIn,
+			LinesInput:  res.LinesParsed,
+			LinesOutput: res.LinesWritten,
+		}
+		if !StartParams.Stdout {
+			Report.Files.Output = StartParams.Output
+			Report.Files.OutputBytes = res.SizeOut
+		}
+		if err := Report.WriteFile(StartParams.Report); err != nil {
+			return nil, err
+		}
+	}
+	if Report.Verify != nil {
+		if err := Report.Verify.Err(); err != nil {
+			return nil, fmt.Errorf("-verify: %s", err)
+		}
+	}
+	return res, nil
+}
+
+// Compares the input surface to the written output. OBJ output is read back so the
+// writer precision is included, other formats are compared to the processed model.
+func verifyOutput(ctx context.Context, before *surface, obj *objectfile.OBJ, encoder Encoder) (*CompareResult, error) {
+	logInfo(" ")
+	after := obj
+	if !StartParams.Stdout && encoder.Name() == (ObjEncoder{}).Name() {
+		var err error
+		if after, _, _, err = parseInput(StartParams.Output); err != nil {
+			return nil, fmt.Errorf("-verify: reading %s: %s", StartParams.Output, err)
+		}
+		logInfo("verify: %s", StartParams.Output)
+	} else {
+		logInfo("verify: processed model, %s output is not read back", encoder.Name())
+	}
+	result, err := compareSurfaces(ctx, before, newSurface(after), "input", "output", StartParams.VerifyOptions)
+	if err != nil {
+		return nil, err
+	}
+	logCompareResult(result)
+	return result, nil
+}
+
+type workersKey struct{}
+
+// Returns ctx with the number of worker goroutines processors may use, see -workers.
+func withWorkers(ctx context.Context, workers int) context.Context {
+	return context.WithValue(ctx, workersKey{}, workers)
+}
+
+// Returns the worker count set with withWorkers, runtime.NumCPU() if not set.
+func contextWorkers(ctx context.Context) int {
+	if workers, ok := ctx.Value(workersKey{}).(int); ok && workers > 0 {
+		return workers
+	}
+	return runtime.NumCPU()
+}
+
+// Runs the processor and returns its report details. ContextProcessors are run
+// with ctx, processors that only implement Execute are run if ctx is not done.
+func (p *processor) Run(ctx context.Context, obj *objectfile.OBJ) (interface{}, error) {
+	report := Report.Processor(p.Name())
+	report.Details = make(map[string]interface{})
+	if cp, ok := p.Processor.(ContextProcessor); ok {
+		result, err := cp.Run(ctx, obj, newProgressReporter(p.Name()))
+		if err != nil {
+			return nil, err
+		}
+		report.Details = result
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := p.Execute(obj); err != nil {
+		return nil, err
+	}
+	return report.Details, nil
+}
+
+func isInPipeline(p *processor) bool {
+	for _, step := range Pipeline {
+		if step == p && !p.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Logs the full stats block of a run.
+func logRunStats(res *runResult) {
+	logInfo(" ")
+	for _, timing := range res.Timings {
+		logResultsPostfix(timing.Step, formatDuration(timing.Duration), computeDurationPerc(timing.Duration, res.Duration)+"%%")
+	}
+	logResults("Total", formatDuration(res.Duration))
+
+	logGeometryStats(res.Pre.Geometry, res.Post.Geometry)
+	logVertexDataStats(res.Pre, res.Post)
+	logObjectStats(res.Pre, res.Post)
+	logFileStats(res.LinesParsed, res.LinesWritten, res.SizeIn, res.SizeOut)
+
+	if StartParams.IsGzipEnabled() {
+		logInfo(" ")
+		logInfo("Gzip compression enabled with level %d.", StartParams.Gzip)
+		logInfo("Remeber to set 'Content-Encoding: gzip' header if you are hosting this file over HTTP.")
+	}
+
+	if len(StartParams.Report) > 0 {
+		logInfo(" ")
+		logInfo("Report written to %s", StartParams.Report)
+	}
+}
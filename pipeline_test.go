@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the testdata golden files.")
+
+// Runs the default pipeline on obj with workers goroutines and returns the -deterministic OBJ output.
+func processForTest(t testing.TB, obj *objectfile.OBJ, workers int) []byte {
+	t.Helper()
+	defer func(sp startParams) { StartParams = sp }(StartParams)
+	StartParams.Deterministic = true
+
+	for _, p := range defaultPipeline() {
+		if p.Disabled {
+			continue
+		}
+		if _, err := p.Run(withWorkers(context.Background(), workers), obj); err != nil {
+			t.Fatalf("%s: %s", p.Name(), err)
+		}
+	}
+	var out bytes.Buffer
+	if _, err := (ObjEncoder{}).Encode(&out, obj, ""); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// testdata/<name>.obj processed with the default options must match
+// testdata/<name>.obj.golden, update with: go test -run TestGolden -update
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.obj"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("no testdata")
+	}
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".obj"), func(t *testing.T) {
+			obj, _, err := ParseFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := processForTest(t, obj, 4)
+			if *updateGolden {
+				if err := os.WriteFile(path+".golden", got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s.golden\n--- got\n%s\n--- want\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// -deterministic output is byte identical across runs and worker counts.
+func TestDeterministicOutput(t *testing.T) {
+	var src bytes.Buffer
+	if _, err := generateOBJ(&src, GenerateOptions{Vertices: 2000, Duplicates: 0.5, Noise: 1e-7, Objects: 4, Materials: 3, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var first []byte
+	for _, workers := range []int{1, 1, 3, 8, 16} {
+		obj, _, err := parse(bytes.NewReader(src.Bytes()), "generated.obj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := processForTest(t, obj, workers)
+		if first == nil {
+			first = out
+		} else if !bytes.Equal(first, out) {
+			t.Fatalf("output with %d workers differs from the first run", workers)
+		}
+	}
+}
+
+// Processors that run again must use the indexes renumbered by the earlier run.
+func TestPipelineRepeatedDuplicates(t *testing.T) {
+	defer func(sp startParams, pipeline []*processor) { StartParams, Pipeline = sp, pipeline }(StartParams, Pipeline)
+
+	dir := t.TempDir()
+	var src bytes.Buffer
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&src, "v %g 0 0\n", float64(i)*0.6)
+	}
+	for i := 1; i+2 <= 300; i++ {
+		fmt.Fprintf(&src, "f %d %d %d\n", i, i+1, i+2)
+	}
+	StartParams.Input = filepath.Join(dir, "line.obj")
+	StartParams.Output = filepath.Join(dir, "line.simplified.obj")
+	for _, p := range Processors {
+		if d, ok := p.Processor.(*Duplicates); ok {
+			defer saveOptions(&d.options)()
+			d.options.Epsilon = 1
+		}
+	}
+	if err := os.WriteFile(StartParams.Input, src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if Pipeline, err = parsePipeline("duplicates,duplicates"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPipeline(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newValidator(StartParams.Output)
+	if err := v.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range v.issues {
+		if issue.Severity == severityError {
+			t.Error(issue)
+		}
+	}
+	obj, _, err := ParseFile(StartParams.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReferences(t, obj)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Plugin exchange format, see the README. Bump pluginFormatVersion
+// when fields are renamed, removed or change meaning.
+const (
+	pluginFormatName    = "obj-simplify-plugin"
+	pluginFormatVersion = 1
+)
+
+type pluginModel struct {
+	Format            string          `json:"format"`
+	Version           int             `json:"version"`
+	Comments          []string        `json:"comments,omitempty"`
+	MaterialLibraries []string        `json:"material_libraries,omitempty"`
+	Geometry          pluginGeometry  `json:"geometry"`
+	Objects           []*pluginObject `json:"objects"`
+}
+
+// Values are [x, y, z] or [x, y, z, w], UVs [u, v] or [u, v, w].
+type pluginGeometry struct {
+	Vertices [][]float64 `json:"v"`
+	Normals  [][]float64 `json:"vn,omitempty"`
+	UVs      [][]float64 `json:"vt,omitempty"`
+	Params   [][]float64 `json:"vp,omitempty"`
+	// Vertex colors [r, g, b] by vertex, null for vertices without a color.
+	// Omitted if no vertex declares a color.
+	Colors [][]float64 `json:"colors,omitempty"`
+}
+
+type pluginObject struct {
+	// "o" or "g"
+	Type     string           `json:"type"`
+	Name     string           `json:"name"`
+	Material string           `json:"material,omitempty"`
+	Comments []string         `json:"comments,omitempty"`
+	Elements []*pluginElement `json:"elements"`
+	// Index of the object in objects this object was split from
+	// by usemtl, omitted if it was not split.
+	Origin *int `json:"origin,omitempty"`
+}
+
+// Face, line or point. Indexes start from 1 like in OBJ, 0 is an
+// undeclared index. vt and vn are omitted if no corner declares them.
+type pluginElement struct {
+	// "f", "l" or "p"
+	Type      string `json:"type"`
+	V         []int  `json:"v"`
+	VT        []int  `json:"vt,omitempty"`
+	VN        []int  `json:"vn,omitempty"`
+	Smoothing string `json:"s,omitempty"`
+}
+
+var pluginGeometryTypes = []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param}
+
+func newPluginModel(obj *objectfile.OBJ) *pluginModel {
+	model := &pluginModel{
+		Format:            pluginFormatName,
+		Version:           pluginFormatVersion,
+		Comments:          obj.Comments,
+		MaterialLibraries: obj.MaterialLibraries,
+		Objects:           make([]*pluginObject, 0, len(obj.Objects)),
+	}
+	hasColors := false
+	for _, t := range pluginGeometryTypes {
+		values := make([][]float64, 0, len(obj.Geometry.Get(t)))
+		for _, gv := range obj.Geometry.Get(t) {
+			values = append(values, pluginGeometryValue(t, gv))
+			hasColors = hasColors || gv.Color != nil
+		}
+		model.Geometry.set(t, values)
+	}
+	if hasColors {
+		model.Geometry.Colors = make([][]float64, len(obj.Geometry.Vertices))
+		for i, gv := range obj.Geometry.Vertices {
+			if gv.Color != nil {
+				model.Geometry.Colors[i] = []float64{gv.Color.R, gv.Color.G, gv.Color.B}
+			}
+		}
+	}
+
+	origins := make(map[*objectfile.Object]int)
+	for i, child := range obj.Objects {
+		origins[child] = i
+	}
+	for _, child := range obj.Objects {
+		o := &pluginObject{
+			Type:     child.Type.String(),
+			Name:     child.Name,
+			Material: child.Material,
+			Comments: child.Comments,
+			Elements: make([]*pluginElement, 0, len(child.VertexData)),
+		}
+		if i, ok := origins[child.Origin]; ok && child.Origin != child {
+			o.Origin = &i
+		}
+		for _, vd := range child.VertexData {
+			e := &pluginElement{
+				Type:      vd.Type.String(),
+				V:         make([]int, len(vd.Declarations)),
+				Smoothing: vd.Meta(objectfile.SmoothingGroup),
+			}
+			hasUVs, hasNormals := false, false
+			for _, decl := range vd.Declarations {
+				hasUVs = hasUVs || decl.Index(objectfile.UV) != 0
+				hasNormals = hasNormals || decl.Index(objectfile.Normal) != 0
+			}
+			if hasUVs {
+				e.VT = make([]int, len(vd.Declarations))
+			}
+			if hasNormals {
+				e.VN = make([]int, len(vd.Declarations))
+			}
+			for i, decl := range vd.Declarations {
+				e.V[i] = decl.Index(objectfile.Vertex)
+				if hasUVs {
+					e.VT[i] = decl.Index(objectfile.UV)
+				}
+				if hasNormals {
+					e.VN[i] = decl.Index(objectfile.Normal)
+				}
+			}
+			o.Elements = append(o.Elements, e)
+		}
+		model.Objects = append(model.Objects, o)
+	}
+	return model
+}
+
+// Omits w if it is the spec default.
+func pluginGeometryValue(t objectfile.Type, gv *objectfile.GeometryValue) []float64 {
+	switch t {
+	case objectfile.Vertex:
+		if gv.W != 1 && gv.Color == nil {
+			return []float64{gv.X, gv.Y, gv.Z, gv.W}
+		}
+		return []float64{gv.X, gv.Y, gv.Z}
+	case objectfile.Normal:
+		return []float64{gv.X, gv.Y, gv.Z}
+	}
+	if gv.Z != 0 {
+		return []float64{gv.X, gv.Y, gv.Z}
+	}
+	return []float64{gv.X, gv.Y}
+}
+
+func (g *pluginGeometry) set(t objectfile.Type, values [][]float64) {
+	switch t {
+	case objectfile.Vertex:
+		g.Vertices = values
+	case objectfile.Normal:
+		g.Normals = values
+	case objectfile.UV:
+		g.UVs = values
+	case objectfile.Param:
+		g.Params = values
+	}
+}
+
+func (g *pluginGeometry) get(t objectfile.Type) [][]float64 {
+	switch t {
+	case objectfile.Vertex:
+		return g.Vertices
+	case objectfile.Normal:
+		return g.Normals
+	case objectfile.UV:
+		return g.UVs
+	case objectfile.Param:
+		return g.Params
+	}
+	return nil
+}
+
+// Converts the model back to an OBJ. All indexes are validated, the OBJ
+// is not modified by the processors if the plugin output is invalid.
+func (model *pluginModel) OBJ() (*objectfile.OBJ, error) {
+	if model.Format != pluginFormatName || model.Version != pluginFormatVersion {
+		return nil, fmt.Errorf("format must be %q version %d, got %q version %d", pluginFormatName, pluginFormatVersion, model.Format, model.Version)
+	}
+	obj := objectfile.NewOBJ()
+	obj.Comments = model.Comments
+	obj.MaterialLibraries = model.MaterialLibraries
+
+	for _, t := range pluginGeometryTypes {
+		min := 2
+		if t == objectfile.Vertex || t == objectfile.Normal {
+			min = 3
+		}
+		for i, value := range model.Geometry.get(t) {
+			if len(value) < min || len(value) > 4 {
+				return nil, fmt.Errorf("%s #%d has %d components", t, i+1, len(value))
+			}
+			gv := &objectfile.GeometryValue{}
+			if t == objectfile.Vertex {
+				gv.W = 1
+			}
+			for c, f := range value {
+				if math.IsNaN(f) || math.IsInf(f, 0) {
+					return nil, fmt.Errorf("%s #%d is not a finite number", t, i+1)
+				}
+				switch c {
+				case 0:
+					gv.X = f
+				case 1:
+					gv.Y = f
+				case 2:
+					gv.Z = f
+				case 3:
+					gv.W = f
+				}
+			}
+			obj.Geometry.Append(t, gv)
+		}
+	}
+	if len(model.Geometry.Colors) > 0 {
+		if len(model.Geometry.Colors) != len(obj.Geometry.Vertices) {
+			return nil, fmt.Errorf("%d vertex colors for %d vertices", len(model.Geometry.Colors), len(obj.Geometry.Vertices))
+		}
+		for i, color := range model.Geometry.Colors {
+			if color == nil {
+				continue
+			} else if len(color) != 3 {
+				return nil, fmt.Errorf("vertex color #%d has %d components", i+1, len(color))
+			}
+			obj.Geometry.Vertices[i].Color = &objectfile.Color{R: color[0], G: color[1], B: color[2]}
+		}
+	}
+
+	children := make([]*objectfile.Object, 0, len(model.Objects))
+	for oi, o := range model.Objects {
+		t := objectfile.TypeFromString(o.Type)
+		if t != objectfile.ChildObject && t != objectfile.ChildGroup {
+			return nil, fmt.Errorf("object #%d has invalid type %q", oi+1, o.Type)
+		}
+		child := obj.CreateObject(t, o.Name, o.Material)
+		child.Comments = o.Comments
+		for ei, e := range o.Elements {
+			vd, err := e.vertexData(obj.Geometry)
+			if err != nil {
+				return nil, fmt.Errorf("object %q element #%d: %s", child.Name, ei+1, err)
+			}
+			child.VertexData = append(child.VertexData, vd)
+		}
+		children = append(children, child)
+	}
+	for oi, o := range model.Objects {
+		if o.Origin == nil {
+			continue
+		} else if *o.Origin < 0 || *o.Origin >= len(children) {
+			return nil, fmt.Errorf("object %q has invalid origin %d", children[oi].Name, *o.Origin)
+		}
+		children[oi].Origin = children[*o.Origin]
+	}
+	return obj, nil
+}
+
+func (e *pluginElement) vertexData(geometry *objectfile.Geometry) (*objectfile.VertexData, error) {
+	t := objectfile.TypeFromString(e.Type)
+	min := 0
+	switch t {
+	case objectfile.Face:
+		min = 3
+	case objectfile.Line:
+		min = 2
+	case objectfile.Point:
+		min = 1
+	default:
+		return nil, fmt.Errorf("invalid type %q", e.Type)
+	}
+	if len(e.V) < min {
+		return nil, fmt.Errorf("%s has %d corners", e.Type, len(e.V))
+	}
+	if (e.VT != nil && len(e.VT) != len(e.V)) || (e.VN != nil && len(e.VN) != len(e.V)) {
+		return nil, fmt.Errorf("v, vt and vn lengths differ")
+	}
+	ref := func(t objectfile.Type, indexes []int, i int) (*objectfile.GeometryValue, error) {
+		if indexes == nil || (indexes[i] == 0 && t != objectfile.Vertex) {
+			return nil, nil
+		}
+		values := geometry.Get(t)
+		if indexes[i] < 1 || indexes[i] > len(values) {
+			return nil, fmt.Errorf("%s index %d out of range 1-%d", t, indexes[i], len(values))
+		}
+		return values[indexes[i]-1], nil
+	}
+	vd := &objectfile.VertexData{Type: t}
+	for i := range e.V {
+		v, err := ref(objectfile.Vertex, e.V, i)
+		if err != nil {
+			return nil, err
+		}
+		vt, err := ref(objectfile.UV, e.VT, i)
+		if err != nil {
+			return nil, err
+		}
+		vn, err := ref(objectfile.Normal, e.VN, i)
+		if err != nil {
+			return nil, err
+		}
+		vd.Declarations = append(vd.Declarations, objectfile.NewDeclaration(v, vt, vn))
+	}
+	if len(e.Smoothing) > 0 {
+		vd.SetMeta(objectfile.SmoothingGroup, e.Smoothing)
+	}
+	return vd, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Plugin is an external processor executable. The model is written to its stdin
+// in the plugin format (see plugin-format.go) and the modified model read from its stdout.
+// Lines written to stderr are logged.
+type Plugin struct {
+	name    string
+	Command []string
+}
+
+// PluginResult is the report of a run.
+type PluginResult struct {
+	Command     string `json:"command"`
+	BytesIn     int    `json:"bytes_in"`
+	BytesOut    int    `json:"bytes_out"`
+	StderrLines int    `json:"stderr_lines"`
+}
+
+// Plugins defined with -plugin and the config file, run by listing them in -pipeline.
+var Plugins []*processor
+
+var pluginNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+func (p *Plugin) Desc() string {
+	return "External processor: " + strings.Join(p.Command, " ")
+}
+
+func (p *Plugin) Options() interface{} {
+	return nil
+}
+
+func (p *Plugin) Execute(obj *objectfile.OBJ) error {
+	_, err := p.Run(context.Background(), obj, newProgressReporter(p.Name()))
+	return err
+}
+
+func (p *Plugin) Run(ctx context.Context, obj *objectfile.OBJ, progress ProgressReporter) (interface{}, error) {
+	input, err := json.Marshal(newPluginModel(obj))
+	if err != nil {
+		return nil, err
+	}
+	result := &PluginResult{
+		Command: strings.Join(p.Command, " "),
+		BytesIn: len(input),
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("OBJ_SIMPLIFY_PLUGIN_VERSION=%d", pluginFormatVersion),
+		"OBJ_SIMPLIFY_INPUT="+StartParams.Input,
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	// children of the plugin may keep stderr open after it was killed
+	cmd.WaitDelay = 5 * time.Second
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %s", p.name, err)
+	}
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		logInfo("  [%s] %s", p.name, scanner.Text())
+		result.StderrLines++
+	}
+	// drain overly long lines so the plugin does not block on a full pipe
+	io.Copy(io.Discard, stderr)
+
+	err = cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	} else if err != nil {
+		return nil, fmt.Errorf("plugin %s: %s", p.name, err)
+	}
+	result.BytesOut = stdout.Len()
+
+	model := &pluginModel{}
+	if err := json.Unmarshal(stdout.Bytes(), model); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid output: %s", p.name, err)
+	}
+	out, err := model.OBJ()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid output: %s", p.name, err)
+	}
+	// materials are not sent to the plugin
+	obj.Geometry = out.Geometry
+	obj.Objects = out.Objects
+	obj.Comments = out.Comments
+	obj.MaterialLibraries = out.MaterialLibraries
+	return result, nil
+}
+
+// Defines plugin name to run command. The command is split by whitespace,
+// the first field is the executable.
+func addPlugin(name, command string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !pluginNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q, use lowercase letters, numbers, - and _", name)
+	} else if findProcessor(name) != nil || findPlugin(name) != nil {
+		return fmt.Errorf("%q is already a processor or plugin name", name)
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return fmt.Errorf("plugin %q has no command", name)
+	}
+	Plugins = append(Plugins, &processor{Processor: &Plugin{name: name, Command: fields}})
+	return nil
+}
+
+func findPlugin(name string) *processor {
+	for _, p := range Plugins {
+		if p.Name() == strings.ToLower(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func pluginNames() []string {
+	names := make([]string, 0, len(Plugins))
+	for _, p := range Plugins {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Returns the plugin commands keyed by name.
+func pluginCommands() map[string]string {
+	commands := make(map[string]string)
+	for _, p := range Plugins {
+		commands[p.Name()] = strings.Join(p.Processor.(*Plugin).Command, " ")
+	}
+	return commands
+}
+
+// pluginFlag is the repeatable -plugin name=command flag.
+type pluginFlag struct{}
+
+func (pluginFlag) String() string {
+	commands := pluginCommands()
+	definitions := make([]string, 0, len(commands))
+	for name, command := range commands {
+		definitions = append(definitions, name+"="+command)
+	}
+	sort.Strings(definitions)
+	return strings.Join(definitions, ", ")
+}
+
+func (pluginFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i == -1 {
+		return fmt.Errorf("use name=command, given: %q", value)
+	}
+	return addPlugin(value[0:i], value[i+1:])
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// PLY declares per vertex positions, normals, uvs and colors that faces reference with
+// a single index. Each vertex becomes v and the optional vn/vt with the same index.
+// Per corner "texcoord" face lists are declared as their own vt values.
+
+type plyFormat int
+
+const (
+	plyASCII plyFormat = iota
+	plyBinaryLittleEndian
+	plyBinaryBigEndian
+)
+
+type plyProperty struct {
+	Name string
+	Type string
+	// Count type for list properties, empty for scalars.
+	CountType string
+}
+
+func (p plyProperty) IsList() bool {
+	return len(p.CountType) > 0
+}
+
+type plyElement struct {
+	Name       string
+	Count      int
+	Properties []plyProperty
+}
+
+func (e *plyElement) Has(names ...string) bool {
+	for _, name := range names {
+		if e.Index(name) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns an error if one of the named properties is not a scalar
+// or, with list true, not a list.
+func (e *plyElement) CheckList(list bool, names ...string) error {
+	for _, name := range names {
+		if i := e.Index(name); i != -1 && e.Properties[i].IsList() != list {
+			kind := "a scalar"
+			if list {
+				kind = "a list"
+			}
+			return fmt.Errorf("PLY %s property %q must be %s", e.Name, name, kind)
+		}
+	}
+	return nil
+}
+
+func (e *plyElement) Index(name string) int {
+	for i, p := range e.Properties {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the number of header lines plus the number of elements read.
+func parsePLY(src io.Reader, name string) (*objectfile.OBJ, int, error) {
+	r := bufio.NewReaderSize(src, 64*1024)
+	format, elements, linenum, err := parsePLYHeader(r)
+	if err != nil {
+		return nil, linenum, wrapErrorLine(err, linenum)
+	}
+
+	var values plyValueReader
+	switch format {
+	case plyASCII:
+		values = &plyASCIIReader{r: r, linenum: linenum}
+	case plyBinaryLittleEndian:
+		values = &plyBinaryReader{r: r, order: binary.LittleEndian}
+	case plyBinaryBigEndian:
+		values = &plyBinaryReader{r: r, order: binary.BigEndian}
+	}
+
+	var (
+		dest  = objectfile.NewOBJ()
+		geom  = dest.Geometry
+		child = dest.CreateObject(objectfile.ChildObject, fileBasename(name), "")
+
+		vertices, normals, uvs []*objectfile.GeometryValue
+		// faces are resolved once all vertices have been read
+		faceIndexes   [][]int
+		faceTexcoords [][]float64
+	)
+
+	for _, element := range elements {
+		row := make([][]float64, len(element.Properties))
+		readRow := func(i int) error {
+			for pi, p := range element.Properties {
+				var err error
+				if row[pi], err = values.Read(p, row[pi][:0]); err != nil {
+					return fmt.Errorf("%s:%d property %q: %s", element.Name, i, p.Name, err)
+				}
+			}
+			linenum++
+			return nil
+		}
+
+		switch element.Name {
+		case "vertex":
+			if !element.Has("x", "y", "z") {
+				return nil, linenum, fmt.Errorf("PLY vertex element does not declare x, y and z properties")
+			}
+			var (
+				x, y, z    = element.Index("x"), element.Index("y"), element.Index("z")
+				hasNormals = element.Has("nx", "ny", "nz")
+				hasColors  = element.Has("red", "green", "blue")
+				uvIndexes  = plyUVIndexes(element)
+			)
+			if err := element.CheckList(false, "x", "y", "z", "nx", "ny", "nz", "red", "green", "blue"); err != nil {
+				return nil, linenum, err
+			}
+			for _, pi := range uvIndexes {
+				if err := element.CheckList(false, element.Properties[pi].Name); err != nil {
+					return nil, linenum, err
+				}
+			}
+			for i := 0; i < element.Count; i++ {
+				if err := readRow(i); err != nil {
+					return nil, linenum, err
+				}
+				vertex := &objectfile.GeometryValue{X: row[x][0], Y: row[y][0], Z: row[z][0], W: 1}
+				if hasColors {
+					vertex.Color = &objectfile.Color{}
+					for ci, name := range []string{"red", "green", "blue"} {
+						pi := element.Index(name)
+						vertex.Color.Set(ci, plyColor(element.Properties[pi], row[pi][0]))
+					}
+				}
+				gv, err := geom.Append(objectfile.Vertex, vertex)
+				if err != nil {
+					return nil, linenum, err
+				}
+				vertices = append(vertices, gv)
+
+				if hasNormals {
+					nx, ny, nz := element.Index("nx"), element.Index("ny"), element.Index("nz")
+					if gv, err = geom.Append(objectfile.Normal, &objectfile.GeometryValue{X: row[nx][0], Y: row[ny][0], Z: row[nz][0]}); err != nil {
+						return nil, linenum, err
+					}
+					normals = append(normals, gv)
+				}
+				if uvIndexes != nil {
+					if gv, err = geom.Append(objectfile.UV, &objectfile.GeometryValue{X: row[uvIndexes[0]][0], Y: row[uvIndexes[1]][0]}); err != nil {
+						return nil, linenum, err
+					}
+					uvs = append(uvs, gv)
+				}
+			}
+		case "face":
+			indexes := element.Index("vertex_indices")
+			if indexes == -1 {
+				indexes = element.Index("vertex_index")
+			}
+			if indexes == -1 {
+				return nil, linenum, fmt.Errorf("PLY face element does not declare a vertex_indices property")
+			}
+			if err := element.CheckList(true, element.Properties[indexes].Name, "texcoord"); err != nil {
+				return nil, linenum, err
+			}
+			texcoords := element.Index("texcoord")
+			for i := 0; i < element.Count; i++ {
+				if err := readRow(i); err != nil {
+					return nil, linenum, err
+				}
+				face := make([]int, len(row[indexes]))
+				for ii, index := range row[indexes] {
+					face[ii] = int(index)
+				}
+				faceIndexes = append(faceIndexes, face)
+				if texcoords != -1 {
+					faceTexcoords = append(faceTexcoords, append([]float64(nil), row[texcoords]...))
+				}
+			}
+		default:
+			// eg. edge, material and tristrips
+			for i := 0; i < element.Count; i++ {
+				if err := readRow(i); err != nil {
+					return nil, linenum, err
+				}
+			}
+			logWarn("Ignored %d PLY %q elements", element.Count, element.Name)
+		}
+	}
+
+	for fi, face := range faceIndexes {
+		if len(face) < 3 {
+			return nil, linenum, fmt.Errorf("face:%d declares %d vertices, at least 3 required", fi, len(face))
+		}
+		var texcoords []float64
+		if faceTexcoords != nil && len(faceTexcoords[fi]) == len(face)*2 {
+			texcoords = faceTexcoords[fi]
+		}
+		vd := &objectfile.VertexData{Type: objectfile.Face}
+		for ci, index := range face {
+			if index < 0 || index >= len(vertices) {
+				return nil, linenum, fmt.Errorf("face:%d vertex index %d out of bounds, %d vertices declared", fi, index, len(vertices))
+			}
+			var uv, normal *objectfile.GeometryValue
+			if texcoords != nil {
+				var err error
+				if uv, err = geom.Append(objectfile.UV, &objectfile.GeometryValue{X: texcoords[ci*2], Y: texcoords[ci*2+1]}); err != nil {
+					return nil, linenum, err
+				}
+			} else if uvs != nil {
+				uv = uvs[index]
+			}
+			if normals != nil {
+				normal = normals[index]
+			}
+			vd.Declarations = append(vd.Declarations, objectfile.NewDeclaration(vertices[index], uv, normal))
+		}
+		child.VertexData = append(child.VertexData, vd)
+	}
+	return dest, linenum, nil
+}
+
+func parsePLYHeader(r *bufio.Reader) (format plyFormat, elements []*plyElement, linenum int, err error) {
+	var element *plyElement
+	for {
+		line, errRead := r.ReadString('\n')
+		if errRead != nil {
+			return format, nil, linenum, fmt.Errorf("PLY header not terminated with end_header: %s", errRead)
+		}
+		linenum++
+
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		if linenum == 1 && parts[0] != "ply" {
+			return format, nil, linenum, fmt.Errorf("Invalid PLY magic %q", parts[0])
+		}
+		switch parts[0] {
+		case "ply", "comment", "obj_info":
+		case "format":
+			if len(parts) < 2 {
+				return format, nil, linenum, fmt.Errorf("Invalid PLY format %q", line)
+			}
+			switch parts[1] {
+			case "ascii":
+				format = plyASCII
+			case "binary_little_endian":
+				format = plyBinaryLittleEndian
+			case "binary_big_endian":
+				format = plyBinaryBigEndian
+			default:
+				return format, nil, linenum, fmt.Errorf("Unsupported PLY format %q", parts[1])
+			}
+		case "element":
+			if len(parts) != 3 {
+				return format, nil, linenum, fmt.Errorf("Invalid PLY element %q", strings.TrimSpace(line))
+			}
+			count, errCount := strconv.Atoi(parts[2])
+			if errCount != nil || count < 0 {
+				return format, nil, linenum, fmt.Errorf("Invalid PLY element count %q", parts[2])
+			}
+			element = &plyElement{Name: parts[1], Count: count}
+			elements = append(elements, element)
+		case "property":
+			if element == nil {
+				return format, nil, linenum, fmt.Errorf("PLY property declared before an element")
+			}
+			var p plyProperty
+			if len(parts) == 5 && parts[1] == "list" {
+				p = plyProperty{CountType: parts[2], Type: parts[3], Name: parts[4]}
+			} else if len(parts) == 3 {
+				p = plyProperty{Type: parts[1], Name: parts[2]}
+			} else {
+				return format, nil, linenum, fmt.Errorf("Invalid PLY property %q", strings.TrimSpace(line))
+			}
+			for _, t := range []string{p.Type, p.CountType} {
+				if len(t) > 0 && plyTypeSize(t) == 0 {
+					return format, nil, linenum, fmt.Errorf("Unsupported PLY property type %q", t)
+				}
+			}
+			element.Properties = append(element.Properties, p)
+		case "end_header":
+			return format, elements, linenum, nil
+		default:
+			return format, nil, linenum, fmt.Errorf("Unsupported PLY header line %q", strings.TrimSpace(line))
+		}
+	}
+}
+
+// Returns the u and v property indexes or nil if not declared.
+// Exporters use different names for the same data.
+func plyUVIndexes(element *plyElement) []int {
+	for _, names := range [][2]string{{"u", "v"}, {"s", "t"}, {"texture_u", "texture_v"}, {"texture_s", "texture_t"}} {
+		if element.Has(names[0], names[1]) {
+			return []int{element.Index(names[0]), element.Index(names[1])}
+		}
+	}
+	return nil
+}
+
+// Integer colors are in the 0-255 range, floats in 0-1.
+func plyColor(p plyProperty, value float64) float64 {
+	switch p.Type {
+	case "float", "float32", "double", "float64":
+		return value
+	}
+	return value / 255
+}
+
+// Returns the binary size of type t, 0 if not supported.
+func plyTypeSize(t string) int {
+	switch t {
+	case "char", "int8", "uchar", "uint8":
+		return 1
+	case "short", "int16", "ushort", "uint16":
+		return 2
+	case "int", "int32", "uint", "uint32", "float", "float32":
+		return 4
+	case "double", "float64":
+		return 8
+	}
+	return 0
+}
+
+type plyValueReader interface {
+	// Reads property p and appends the values to dest.
+	// Scalars append a single value, lists append all the list values.
+	Read(p plyProperty, dest []float64) ([]float64, error)
+}
+
+type plyASCIIReader struct {
+	r       *bufio.Reader
+	linenum int
+	fields  []string
+}
+
+func (ar *plyASCIIReader) Read(p plyProperty, dest []float64) ([]float64, error) {
+	count := 1
+	if p.IsList() {
+		n, err := ar.next()
+		if err != nil {
+			return dest, err
+		}
+		if count = int(n); count < 0 {
+			return dest, fmt.Errorf("Invalid list length %d", count)
+		}
+	}
+	for i := 0; i < count; i++ {
+		value, err := ar.next()
+		if err != nil {
+			return dest, err
+		}
+		dest = append(dest, value)
+	}
+	return dest, nil
+}
+
+// Values are whitespace separated, one element per line is not required.
+func (ar *plyASCIIReader) next() (float64, error) {
+	for len(ar.fields) == 0 {
+		line, err := ar.r.ReadString('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		ar.linenum++
+		ar.fields = strings.Fields(line)
+	}
+	field := ar.fields[0]
+	ar.fields = ar.fields[1:]
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line:%d %s", ar.linenum, err)
+	}
+	return value, nil
+}
+
+type plyBinaryReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (br *plyBinaryReader) Read(p plyProperty, dest []float64) ([]float64, error) {
+	count := 1
+	if p.IsList() {
+		n, err := br.next(p.CountType)
+		if err != nil {
+			return dest, err
+		}
+		if count = int(n); count < 0 {
+			return dest, fmt.Errorf("Invalid list length %d", count)
+		}
+	}
+	for i := 0; i < count; i++ {
+		value, err := br.next(p.Type)
+		if err != nil {
+			return dest, err
+		}
+		dest = append(dest, value)
+	}
+	return dest, nil
+}
+
+func (br *plyBinaryReader) next(t string) (float64, error) {
+	b := br.buf[0:plyTypeSize(t)]
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return 0, err
+	}
+	switch t {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(br.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(br.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(br.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(br.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(br.order.Uint32(b))), nil
+	case "double", "float64":
+		return math.Float64frombits(br.order.Uint64(b)), nil
+	}
+	return 0, fmt.Errorf("Unsupported type %q", t)
+}
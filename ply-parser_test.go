@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Headers that declare lists where scalars are expected, or the other way
+// around, are errors instead of index out of range panics.
+func TestParsePLYPropertyKinds(t *testing.T) {
+	const faces = "element face 1\nproperty list uchar int vertex_indices\n"
+	for _, tc := range []struct {
+		name, header, data string
+	}{
+		{"list x", "element vertex 3\nproperty list uchar float x\nproperty float y\nproperty float z\n" + faces, "0 0 0\n0 1 0\n0 0 1\n3 0 1 2\n"},
+		{"list normal", "element vertex 3\nproperty float x\nproperty float y\nproperty float z\nproperty float nx\nproperty list uchar float ny\nproperty float nz\n" + faces, "0 0 0 0 0 0 1\n1 0 0 0 0 0 1\n0 1 0 0 0 0 1\n3 0 1 2\n"},
+		{"list uv", "element vertex 3\nproperty float x\nproperty float y\nproperty float z\nproperty float u\nproperty list uchar float v\n" + faces, "0 0 0 0 0\n1 0 0 1 0\n0 1 0 0 0\n3 0 1 2\n"},
+		{"scalar indices", "element vertex 3\nproperty float x\nproperty float y\nproperty float z\nelement face 1\nproperty int vertex_indices\n", "0 0 0\n1 0 0\n0 1 0\n0\n"},
+	} {
+		src := "ply\nformat ascii 1.0\n" + tc.header + "end_header\n" + tc.data
+		if _, _, err := parsePLY(strings.NewReader(src), "model.ply"); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+
+	src := "ply\nformat ascii 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\n" + faces + "end_header\n0 0 0\n1 0 0\n0 1 0\n3 0 1 2\n"
+	obj, _, err := parsePLY(strings.NewReader(src), "model.ply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReferences(t, obj)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// PlyEncoder writes binary little endian PLY with indexed faces. Materials are
+// written as a material_index face property, the names are listed in the
+// header as "comment material <index> <name>".
+type PlyEncoder struct{}
+
+func (enc PlyEncoder) Name() string {
+	return "ply"
+}
+
+func (enc PlyEncoder) Desc() string {
+	return "Binary little endian PLY with normals, UVs, vertex colors and material indexes"
+}
+
+func (enc PlyEncoder) Extensions() []string {
+	return []string{".ply"}
+}
+
+// Returns the number of vertex and face elements written.
+func (enc PlyEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	m := buildMesh(obj)
+	if m.Skipped > 0 {
+		logWarn("PLY does not support lines and points, %d declarations not written", m.Skipped)
+	}
+
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(wc)
+
+	// list length is an uchar, larger faces are triangulated
+	faces := make([]meshFace, 0, len(m.Faces))
+	for _, face := range m.Faces {
+		if len(face.Indexes) <= math.MaxUint8 {
+			faces = append(faces, face)
+			continue
+		}
+		for _, triangle := range face.Triangles() {
+			faces = append(faces, meshFace{Indexes: triangle[:], Material: face.Material})
+		}
+	}
+
+	fmt.Fprint(w, "ply\nformat binary_little_endian 1.0\n")
+	if !StartParams.NoHeader {
+		for _, line := range strings.Split(headerComment(), "\n") {
+			fmt.Fprintf(w, "comment %s\n", line)
+		}
+	}
+	if m.HasMaterials() {
+		for i, name := range m.Materials {
+			fmt.Fprintf(w, "comment material %d %s\n", i, name)
+		}
+	}
+	fmt.Fprintf(w, "element vertex %d\n", len(m.Positions))
+	fmt.Fprint(w, "property float x\nproperty float y\nproperty float z\n")
+	if m.HasNormals {
+		fmt.Fprint(w, "property float nx\nproperty float ny\nproperty float nz\n")
+	}
+	if m.HasUVs {
+		fmt.Fprint(w, "property float s\nproperty float t\n")
+	}
+	if m.HasColors {
+		fmt.Fprint(w, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	}
+	fmt.Fprintf(w, "element face %d\n", len(faces))
+	fmt.Fprint(w, "property list uchar int vertex_indices\n")
+	if m.HasMaterials() {
+		fmt.Fprint(w, "property int material_index\n")
+	}
+	fmt.Fprint(w, "end_header\n")
+
+	var (
+		buf     [4]byte
+		writeU8 = func(v uint8) {
+			w.WriteByte(v)
+		}
+		writeU32 = func(v uint32) {
+			binary.LittleEndian.PutUint32(buf[:], v)
+			w.Write(buf[:])
+		}
+		writeVector = func(v objectfile.Vector, components int) {
+			for _, f := range []float64{v.X, v.Y, v.Z}[0:components] {
+				writeU32(math.Float32bits(float32(f)))
+			}
+		}
+		colorByte = func(f float64) uint8 {
+			return uint8(math.Round(math.Max(0, math.Min(1, f)) * 255))
+		}
+	)
+	for i, position := range m.Positions {
+		writeVector(position.Vector(), 3)
+		if m.HasNormals {
+			writeVector(meshValue(m.Normals[i]), 3)
+		}
+		if m.HasUVs {
+			writeVector(meshValue(m.UVs[i]), 2)
+		}
+		if m.HasColors {
+			// vertices without a color are white
+			color := objectfile.Color{R: 1, G: 1, B: 1}
+			if position.Color != nil {
+				color = *position.Color
+			}
+			writeU8(colorByte(color.R))
+			writeU8(colorByte(color.G))
+			writeU8(colorByte(color.B))
+		}
+	}
+	for _, face := range faces {
+		writeU8(uint8(len(face.Indexes)))
+		for _, index := range face.Indexes {
+			writeU32(uint32(index))
+		}
+		if m.HasMaterials() {
+			writeU32(uint32(face.Material))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := wc.Close(); err != nil {
+		return 0, err
+	}
+	return len(m.Positions) + len(faces), nil
+}
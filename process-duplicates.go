@@ -1,14 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
 	"time"
 
-	"gopkg.in/cheggaaa/pb.v1"
-
 	"github.com/jonnenauha/obj-simplify/objectfile"
 )
 
@@ -16,8 +16,10 @@ import (
 
 type replacerList []*replacer
 
-// flat map of index to ptr that replaces that index
+// flat map of index to ptr that replaces that index. The list is sorted once here
+// so an index hit by multiple replacers always maps to the same value.
 func (rl replacerList) FlattenGeometry() map[int]*objectfile.GeometryValue {
+	sort.Sort(replacerByIndex(rl))
 	out := make(map[int]*objectfile.GeometryValue)
 	for _, r := range rl {
 		for index, _ := range r.replaces {
@@ -30,10 +32,51 @@ func (rl replacerList) FlattenGeometry() map[int]*objectfile.GeometryValue {
 	return out
 }
 
+// tolerance
+
+// geometryEquals reports if a and b are duplicates of each other.
+type geometryEquals func(a, b *objectfile.GeometryValue) bool
+
+type tolerance struct {
+	Epsilon float64
+	Angle   float64 // degrees, compares direction instead of components if >0
+}
+
+// Resolves the tolerance for each geometry type from the options of the registered Duplicates processor.
+func geometryTolerances(obj *objectfile.OBJ) map[objectfile.Type]tolerance {
+	for _, p := range Processors {
+		if d, ok := p.Processor.(*Duplicates); ok {
+			return d.options.Tolerances(obj)
+		}
+	}
+	return newDuplicates().options.Tolerances(obj)
+}
+
+func (tol tolerance) Equals() geometryEquals {
+	if tol.Angle > 0 {
+		radians := tol.Angle * math.Pi / 180.0
+		return func(a, b *objectfile.GeometryValue) bool {
+			return a.EqualsAngle(b, radians)
+		}
+	}
+	epsilon := tol.Epsilon
+	return func(a, b *objectfile.GeometryValue) bool {
+		return a.Equals(b, epsilon)
+	}
+}
+
+func (tol tolerance) String() string {
+	if tol.Angle > 0 {
+		return strconv.FormatFloat(tol.Angle, 'g', -1, 64) + " degrees"
+	}
+	return "epsilon " + strconv.FormatFloat(tol.Epsilon, 'g', -1, 64)
+}
+
 // replacer
 
 type replacer struct {
 	ref           *objectfile.GeometryValue
+	equals        geometryEquals
 	replaces      map[int]*objectfile.GeometryValue
 	replacesSlice []*objectfile.GeometryValue
 	dirty         bool
@@ -55,6 +98,8 @@ func (r *replacer) NumReplaces() int {
 	return 0
 }
 
+// Returns the replaced values in map order. Merge and deduplicate
+// decide per value, their results do not depend on the order.
 func (r *replacer) Replaces() []*objectfile.GeometryValue {
 	// optimization to avoid huge map iters
 	if r.dirty {
@@ -113,7 +158,7 @@ func (r *replacer) Merge(other *replacer) {
 		if r.hasItems && r.replaces[value.Index] != nil {
 			// straight up duplicate
 			other.Remove(value.Index)
-		} else if r.ref.Equals(value, StartParams.Epsilon) {
+		} else if r.equals(r.ref, value) {
 			// move equals hit to r from other
 			r.Hit(value)
 			other.Remove(value.Index)
@@ -151,33 +196,132 @@ func (rr *replacerResults) Duplicates() (duplicates int) {
 
 // Duplicates
 
-type Duplicates struct{}
+type Duplicates struct {
+	options DuplicatesOptions
+}
+
+// Returns a Duplicates processor with the default options.
+func newDuplicates() *Duplicates {
+	return &Duplicates{
+		options: DuplicatesOptions{
+			Epsilon:   1e-6,
+			EpsilonV:  -1,
+			EpsilonVN: -1,
+			EpsilonVT: -1,
+			EpsilonVP: -1,
+		},
+	}
+}
+
+type DuplicatesOptions struct {
+	Epsilon float64 `flag:"epsilon" usage:"Epsilon for float comparisons."`
+
+	// per geometry type, negative uses Epsilon
+	EpsilonV        float64 `flag:"epsilon-v" usage:"Epsilon for vertex position comparisons. Negative uses -epsilon."`
+	EpsilonVN       float64 `flag:"epsilon-vn" usage:"Epsilon for normal comparisons. Negative uses -epsilon."`
+	EpsilonVT       float64 `flag:"epsilon-vt" usage:"Epsilon for UV comparisons. Negative uses -epsilon."`
+	EpsilonVP       float64 `flag:"epsilon-vp" usage:"Epsilon for parameter space vertex comparisons. Negative uses -epsilon."`
+	EpsilonVNAngle  float64 `flag:"epsilon-vn-angle" usage:"Compare normals by the angle between them in degrees instead of -epsilon-vn. <=0 disables."`
+	EpsilonRelative float64 `flag:"epsilon-relative" usage:"Vertex position epsilon as a fraction of the bounding box diagonal, overrides -epsilon-v. <=0 disables."`
+}
+
+// DuplicatesResult is the report of a run keyed by geometry type.
+type DuplicatesResult map[string]*DuplicatesTypeResult
+
+type DuplicatesTypeResult struct {
+	Epsilon      float64 `json:"epsilon"`
+	Angle        float64 `json:"angle"`
+	Duplicates   int     `json:"duplicates"`
+	Unique       int     `json:"unique"`
+	RefsReplaced int     `json:"refs_replaced"`
+	Seconds      float64 `json:"seconds"`
+}
+
+func (o *DuplicatesOptions) Validate() error {
+	if o.EpsilonVNAngle >= 180 {
+		return fmt.Errorf("-epsilon-vn-angle must be less than 180 degrees, given: %g", o.EpsilonVNAngle)
+	}
+	return nil
+}
+
+// Returns the epsilon for geometry type t, falls back to -epsilon.
+func (o *DuplicatesOptions) EpsilonFor(t objectfile.Type) float64 {
+	epsilon := -1.0
+	switch t {
+	case objectfile.Vertex:
+		epsilon = o.EpsilonV
+	case objectfile.Normal:
+		epsilon = o.EpsilonVN
+	case objectfile.UV:
+		epsilon = o.EpsilonVT
+	case objectfile.Param:
+		epsilon = o.EpsilonVP
+	}
+	if epsilon < 0 {
+		return o.Epsilon
+	}
+	return epsilon
+}
+
+// Resolves the tolerance for each geometry type.
+func (o *DuplicatesOptions) Tolerances(obj *objectfile.OBJ) map[objectfile.Type]tolerance {
+	tolerances := make(map[objectfile.Type]tolerance)
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
+		tolerances[t] = tolerance{Epsilon: o.EpsilonFor(t)}
+	}
+	if o.EpsilonRelative > 0 {
+		tolerances[objectfile.Vertex] = tolerance{Epsilon: o.EpsilonRelative * obj.Geometry.BoundingBox().Diagonal()}
+	}
+	if o.EpsilonVNAngle > 0 {
+		tolerances[objectfile.Normal] = tolerance{Angle: o.EpsilonVNAngle}
+	}
+	return tolerances
+}
 
-func (processor Duplicates) Name() string {
+func (processor *Duplicates) Name() string {
 	return "Duplicates"
 }
 
-func (processor Duplicates) Desc() string {
+func (processor *Duplicates) Desc() string {
 	return "Removes duplicate v/vn/vt declarations. Rewrites vertex data references."
 }
 
-func (processor Duplicates) Execute(obj *objectfile.OBJ) error {
+// Snapped values need to be merged.
+func (processor *Duplicates) RunsAfter() []string {
+	return []string{"quantize"}
+}
+
+func (processor *Duplicates) Options() interface{} {
+	return &processor.options
+}
+
+func (processor *Duplicates) Execute(obj *objectfile.OBJ) error {
+	_, err := processor.Run(context.Background(), obj, newProgressReporter(processor.Name()))
+	return err
+}
+
+func (processor *Duplicates) Run(ctx context.Context, obj *objectfile.OBJ, progress ProgressReporter) (interface{}, error) {
 	var (
-		results         = make(map[objectfile.Type]*replacerResults)
-		mResults        = sync.RWMutex{}
-		wg              = &sync.WaitGroup{}
-		preStats        = obj.Geometry.Stats()
-		epsilon         = StartParams.Epsilon
-		progressEnabled = !StartParams.NoProgress
+		results    = make(map[objectfile.Type]*replacerResults)
+		mResults   = sync.RWMutex{}
+		wg         = &sync.WaitGroup{}
+		preStats   = obj.Geometry.Stats()
+		tolerances = processor.options.Tolerances(obj)
+		// results are logged as they come in if progress is not shown
+		progressShown = false
 	)
 
-	logInfo("  - Using epsilon of %s", strconv.FormatFloat(epsilon, 'g', -1, 64))
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
+		if preStats.Num(t) > 0 {
+			logInfo("  - %-2s using %s", t, tolerances[t])
+		}
+	}
 
 	// Doing this with channels felt a bit overkill, copying a lot of replacers etc.
 	setResults := func(result *replacerResults) {
 		mResults.Lock()
 		// If there is no progress bars, report results as they come in so user knows something is happening...
-		if !progressEnabled {
+		if !progressShown {
 			logInfo("  - %-2s %7d duplicates found for %d unique indexes (%s%%) in %s",
 				result.Type, result.Duplicates(), len(result.Items), computeFloatPerc(float64(result.Duplicates()), float64(preStats.Num(result.Type))), formatDuration(result.Spent))
 		}
@@ -189,36 +333,19 @@ func (processor Duplicates) Execute(obj *objectfile.OBJ) error {
 	{
 		mResults.Lock()
 
-		var (
-			bars         = make(map[objectfile.Type]*pb.ProgressBar)
-			progressPool *pb.Pool
-			progressErr  error
-		)
-		// progress bars
-		if !StartParams.NoProgress {
-			for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
-				if slice := obj.Geometry.Get(t); len(slice) > 0 {
-					bar := pb.New(len(slice)).Prefix(fmt.Sprintf("  - %-2s scan    ", t.String())).SetMaxWidth(130)
-					bar.ShowTimeLeft = false
-					bars[t] = bar
-				}
-			}
-			barsSlice := make([]*pb.ProgressBar, 0)
-			for _, bar := range bars {
-				barsSlice = append(barsSlice, bar)
-			}
-			if progressPool, progressErr = pb.StartPool(barsSlice...); progressErr != nil {
-				// progress pools do not work in all shells on windows (eg. liteide)
-				bars = make(map[objectfile.Type]*pb.ProgressBar)
-				progressPool = nil
-				progressEnabled = false
+		trackers := make(map[objectfile.Type]ProgressTracker)
+		for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
+			if slice := obj.Geometry.Get(t); len(slice) > 0 {
+				trackers[t] = progress.Track(fmt.Sprintf("  - %-2s scan", t.String()), len(slice))
 			}
 		}
+		progressShown = progress.Start()
+
 		// start goroutines
 		for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
 			if slice := obj.Geometry.Get(t); len(slice) > 0 {
 				wg.Add(1)
-				go findDuplicates(t, slice, epsilon, wg, bars[t], setResults)
+				go findDuplicates(ctx, t, slice, tolerances[t].Equals(), wg, trackers[t], setResults)
 			}
 		}
 
@@ -226,11 +353,12 @@ func (processor Duplicates) Execute(obj *objectfile.OBJ) error {
 
 		wg.Wait()
 
-		if progressPool != nil {
-			progressPool.Stop()
+		progress.Stop()
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 		// report totals now in the same order as it would print without progress bars
-		if progressEnabled {
+		if progressShown {
 			mResults.Lock()
 			for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
 				if result := results[t]; result != nil {
@@ -246,9 +374,18 @@ func (processor Duplicates) Execute(obj *objectfile.OBJ) error {
 	// Exec in main thread, accessing the vertex data arrays in objects would be
 	// too much contention with a mutex. This operation is fairly fast, no need for parallel exec.
 	// Sweeps and marks .Discard to replaced values
+	report := make(DuplicatesResult)
 	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.Normal, objectfile.UV, objectfile.Param} {
 		if result := results[t]; result != nil {
-			replaceDuplicates(result.Type, obj, result.Items)
+			replaced := replaceDuplicates(result.Type, obj, result.Items)
+			report[t.String()] = &DuplicatesTypeResult{
+				Epsilon:      tolerances[t].Epsilon,
+				Angle:        tolerances[t].Angle,
+				Duplicates:   result.Duplicates(),
+				Unique:       len(result.Items),
+				RefsReplaced: replaced,
+				Seconds:      result.Spent.Seconds(),
+			}
 		}
 	}
 
@@ -269,10 +406,10 @@ func (processor Duplicates) Execute(obj *objectfile.OBJ) error {
 			obj.Geometry.Set(t, dest)
 		}
 	}
-	return nil
+	return report, nil
 }
 
-func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilon float64, wgMain *sync.WaitGroup, progress *pb.ProgressBar, callback func(*replacerResults)) {
+func findDuplicates(ctx context.Context, t objectfile.Type, slice []*objectfile.GeometryValue, equals geometryEquals, wgMain *sync.WaitGroup, progress ProgressTracker, callback func(*replacerResults)) {
 	defer wgMain.Done()
 
 	var (
@@ -295,15 +432,18 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 		innerResults := make(replacerList, 0)
 		var value *objectfile.GeometryValue
 		for first := substart; first < subend; first++ {
-			if progress != nil {
-				progress.Increment()
+			// checking the context is not free, once per 256 values is responsive enough
+			if first%256 == 0 && ctx.Err() != nil {
+				break
 			}
+			progress.Increment()
 			result := &replacer{
-				ref: fullslice[first],
+				ref:    fullslice[first],
+				equals: equals,
 			}
 			for second, lenFull := first+1, len(fullslice); second < lenFull; second++ {
 				value = fullslice[second]
-				if value.Equals(result.ref, epsilon) {
+				if equals(result.ref, value) {
 					result.Hit(value)
 				}
 			}
@@ -317,13 +457,14 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 	}
 
 	wgInternal := &sync.WaitGroup{}
-	numPerRoutine := len(slice) / StartParams.Workers
-	for iter := 0; iter < StartParams.Workers; iter++ {
+	workers := contextWorkers(ctx)
+	numPerRoutine := len(slice) / workers
+	for iter := 0; iter < workers; iter++ {
 		start := iter * numPerRoutine
 		end := start + numPerRoutine
-		if end >= len(slice) || iter == StartParams.Workers-1 {
+		if end >= len(slice) || iter == workers-1 {
 			end = len(slice)
-			iter = StartParams.Workers
+			iter = workers
 		}
 		wgInternal.Add(1)
 		go processSlice(start, end, slice, wgInternal)
@@ -333,25 +474,22 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 	mResults.Lock()
 	defer mResults.Unlock()
 
-	if len(results) == 0 {
+	if len(results) == 0 || ctx.Err() != nil {
 		return
 	}
 
 	sort.Sort(replacerByIndex(results))
 
-	if progress != nil {
-		progress.Prefix(fmt.Sprintf("  - %-2s merge    ", t))
-		progress.Total = int64(len(results))
-		progress.Set(0)
-	}
+	progress.Reset(fmt.Sprintf("  - %-2s merge", t), len(results))
 
 	var r1, r2 *replacer
 
 	// 1st run: merge
 	for i1, lenResults := 0, len(results); i1 < lenResults; i1++ {
-		if progress != nil {
-			progress.Increment()
+		if i1%256 == 0 && ctx.Err() != nil {
+			return
 		}
+		progress.Increment()
 		r1 = results[i1]
 		if !r1.hasItems {
 			continue
@@ -386,11 +524,10 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 			nonemptyMerged = append(nonemptyMerged, r)
 		}
 	}
-	if progress != nil {
-		progress.Prefix(fmt.Sprintf("  - %-2s deduplicate    ", t))
-		progress.Total = int64(len(nonemptyMerged))
-		progress.Set(0)
+	if ctx.Err() != nil {
+		return
 	}
+	progress.Reset(fmt.Sprintf("  - %-2s deduplicate", t), len(nonemptyMerged))
 
 	// 2nd run: deduplicate, must be done after full merge to work correctly.
 	//
@@ -401,9 +538,10 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 	// if r and other both have a hit index, which is not shared by being
 	// closer than epsilon tp both, keep it in the parent that it is closest to.
 	for i1, lenResults := 0, len(nonemptyMerged); i1 < lenResults; i1++ {
-		if progress != nil {
-			progress.Increment()
+		if i1%256 == 0 && ctx.Err() != nil {
+			return
 		}
+		progress.Increment()
 		r1 = nonemptyMerged[i1]
 		if !r1.hasItems {
 			continue
@@ -427,6 +565,9 @@ func findDuplicates(t objectfile.Type, slice []*objectfile.GeometryValue, epsilo
 		}
 	}
 
+	if ctx.Err() != nil {
+		return
+	}
 	// send results back
 	callback(&replacerResults{
 		Type:  t,
@@ -455,7 +596,7 @@ func deduplicate(r1, r2 *replacer) {
 	}
 }
 
-func replaceDuplicates(t objectfile.Type, obj *objectfile.OBJ, replacements replacerList) {
+func replaceDuplicates(t objectfile.Type, obj *objectfile.OBJ, replacements replacerList) int {
 	rStart := time.Now()
 
 	indexToRef := replacements.FlattenGeometry()
@@ -470,19 +611,19 @@ func replaceDuplicates(t objectfile.Type, obj *objectfile.OBJ, replacements repl
 			for _, decl := range vt.Declarations {
 				switch t {
 				case objectfile.Vertex:
-					if ref := indexToRef[decl.Vertex]; ref != nil {
+					if ref := indexToRef[decl.Index(objectfile.Vertex)]; ref != nil {
 						replaced++
 						decl.RefVertex.Discard = true
 						decl.RefVertex = ref
 					}
 				case objectfile.UV:
-					if ref := indexToRef[decl.UV]; ref != nil {
+					if ref := indexToRef[decl.Index(objectfile.UV)]; ref != nil {
 						replaced++
 						decl.RefUV.Discard = true
 						decl.RefUV = ref
 					}
 				case objectfile.Normal:
-					if ref := indexToRef[decl.Normal]; ref != nil {
+					if ref := indexToRef[decl.Index(objectfile.Normal)]; ref != nil {
 						replaced++
 						decl.RefNormal.Discard = true
 						decl.RefNormal = ref
@@ -492,4 +633,5 @@ func replaceDuplicates(t objectfile.Type, obj *objectfile.OBJ, replacements repl
 		}
 	}
 	logInfo("  - %-2s %7d refs replaced in %s", t, replaced, formatDurationSince(rStart))
+	return replaced
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Duplicates picks the same replacements regardless of worker count and map order.
+func TestDuplicatesDeterministic(t *testing.T) {
+	var src bytes.Buffer
+	// noise around epsilon so values are between two replacers and get deduplicated
+	if _, err := generateOBJ(&src, GenerateOptions{Vertices: 900, Duplicates: 0.8, Noise: 1.5e-6, Objects: 2, Materials: 2, Seed: 5}); err != nil {
+		t.Fatal(err)
+	}
+	var first string
+	for _, workers := range []int{1, 1, 2, 5, 16} {
+		obj, _, err := parse(bytes.NewReader(src.Bytes()), "generated.obj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newDuplicates().Run(withWorkers(context.Background(), workers), obj, silentProgress{}); err != nil {
+			t.Fatal(err)
+		}
+		var indexes strings.Builder
+		for _, child := range obj.Objects {
+			for _, vd := range child.VertexData {
+				indexes.WriteString(vd.String())
+				indexes.WriteString("\n")
+			}
+		}
+		if first == "" {
+			first = indexes.String()
+		} else if indexes.String() != first {
+			t.Fatalf("replacements with %d workers differ from the first run", workers)
+		}
+	}
+}
+
+func BenchmarkFindDuplicates(b *testing.B) {
+	for _, vertices := range []int{1000, 4000, 16000} {
+		obj, _, err := parse(bytes.NewReader(generateForBenchmark(b, vertices)), "generated.obj")
+		if err != nil {
+			b.Fatal(err)
+		}
+		equals := tolerance{Epsilon: newDuplicates().options.Epsilon}.Equals()
+		for _, workers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%dk/workers=%d", vertices/1000, workers), func(b *testing.B) {
+				ctx := withWorkers(context.Background(), workers)
+				for i := 0; i < b.N; i++ {
+					wg := &sync.WaitGroup{}
+					wg.Add(1)
+					findDuplicates(ctx, objectfile.Vertex, obj.Geometry.Vertices, equals, wg, silentProgress{}, func(*replacerResults) {})
+					wg.Wait()
+				}
+			})
+		}
+	}
+}
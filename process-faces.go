@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+type Faces struct{}
+
+func (processor Faces) Name() string {
+	return "Faces"
+}
+
+func (processor Faces) Desc() string {
+	return "Removes degenerate, duplicate and back-to-back coincident faces. Collapses repeated corners in n-gons."
+}
+
+// Removing back-to-back faces deletes the back side of double sided
+// geometry eg. foliage cards, only run if listed in -pipeline.
+func (processor Faces) OptIn() bool {
+	return true
+}
+
+// Duplicate and degenerate faces are found by comparing deduplicated indexes.
+func (processor Faces) RunsAfter() []string {
+	return []string{"duplicates"}
+}
+
+func (processor Faces) Execute(obj *objectfile.OBJ) error {
+	var (
+		epsilon    = geometryTolerances(obj)[objectfile.Vertex].Epsilon
+		collapsed  int // faces that had repeated corners collapsed
+		degenerate int // less than 3 unique corners
+		zeroArea   int
+		duplicates int // same corners in the same winding order
+		coincident int // same corners in the reverse winding order
+		// Faces are compared per material. Coincident faces with different
+		// materials are most likely intentional eg. decals.
+		seen = make(map[string]bool)
+	)
+
+	for _, child := range obj.Objects {
+		kept := make([]*objectfile.VertexData, 0, len(child.VertexData))
+		// smoothing group is only attached to the face where it changes,
+		// move it to the next kept face if that face is removed.
+		pendingSmoothingGroup := ""
+
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				kept = append(kept, vd)
+				continue
+			}
+			if sgroup := vd.Meta(objectfile.SmoothingGroup); len(sgroup) > 0 {
+				pendingSmoothingGroup = sgroup
+			}
+
+			if corners := collapseCorners(vd.Declarations); len(corners) != len(vd.Declarations) {
+				collapsed++
+				vd.Declarations = corners
+			}
+			if vd.NumUniqueVertices() < 3 {
+				degenerate++
+				continue
+			}
+			if vd.Area() <= epsilon*epsilon {
+				zeroArea++
+				continue
+			}
+			forward, reverse := faceKeys(child.Material, vd)
+			if seen[forward] {
+				duplicates++
+				continue
+			} else if seen[reverse] {
+				coincident++
+				continue
+			}
+			seen[forward] = true
+
+			if len(pendingSmoothingGroup) > 0 {
+				vd.SetMeta(objectfile.SmoothingGroup, pendingSmoothingGroup)
+				pendingSmoothingGroup = ""
+			}
+			kept = append(kept, vd)
+		}
+		child.VertexData = kept
+	}
+
+	logInfo("  - %-12s %7d faces", "Collapsed", collapsed)
+	logInfo("  - %-12s %7d faces removed", "Degenerate", degenerate)
+	logInfo("  - %-12s %7d faces removed", "Zero area", zeroArea)
+	logInfo("  - %-12s %7d faces removed", "Duplicate", duplicates)
+	logInfo("  - %-12s %7d faces removed", "Coincident", coincident)
+
+	report := Report.Processor(processor.Name())
+	report.Set("collapsed", collapsed)
+	report.Set("degenerate", degenerate)
+	report.Set("zero_area", zeroArea)
+	report.Set("duplicates", duplicates)
+	report.Set("coincident", coincident)
+	return nil
+}
+
+// Removes consecutive corners that reference the same vertex,
+// including the last corner wrapping around to the first.
+func collapseCorners(decls []*objectfile.Declaration) []*objectfile.Declaration {
+	out := make([]*objectfile.Declaration, 0, len(decls))
+	for _, decl := range decls {
+		if len(out) > 0 && out[len(out)-1].Index(objectfile.Vertex) == decl.Index(objectfile.Vertex) {
+			continue
+		}
+		out = append(out, decl)
+	}
+	for len(out) > 1 && out[0].Index(objectfile.Vertex) == out[len(out)-1].Index(objectfile.Vertex) {
+		out = out[0 : len(out)-1]
+	}
+	return out
+}
+
+// Returns keys that identify the face corners regardless of the starting corner,
+// in the declared and in the reversed winding order.
+func faceKeys(material string, vd *objectfile.VertexData) (forward, reverse string) {
+	indexes := make([]int, len(vd.Declarations))
+	for i, decl := range vd.Declarations {
+		indexes[i] = decl.Index(objectfile.Vertex)
+	}
+	reversed := make([]int, len(indexes))
+	for i, index := range indexes {
+		reversed[len(indexes)-1-i] = index
+	}
+	return material + "|" + canonicalCycle(indexes), material + "|" + canonicalCycle(reversed)
+}
+
+// Returns the lexicographically smallest rotation of indexes as a string.
+func canonicalCycle(indexes []int) string {
+	best := -1
+	for start := range indexes {
+		if best == -1 {
+			best = start
+			continue
+		}
+		for i := 0; i < len(indexes); i++ {
+			a, b := indexes[(start+i)%len(indexes)], indexes[(best+i)%len(indexes)]
+			if a != b {
+				if a < b {
+					best = start
+				}
+				break
+			}
+		}
+	}
+	parts := make([]string, len(indexes))
+	for i := range indexes {
+		parts[i] = strconv.Itoa(indexes[(best+i)%len(indexes)])
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -22,7 +23,23 @@ func (processor Merge) Desc() string {
 	return "Merges objects and groups with the same material into a single mesh."
 }
 
+// MergeResult is the report of a run.
+type MergeResult struct {
+	UniqueMaterials int `json:"unique_materials"`
+	ObjectsIn       int `json:"objects_in"`
+	ObjectsOut      int `json:"objects_out"`
+}
+
+func (processor Merge) Options() interface{} {
+	return nil
+}
+
 func (processor Merge) Execute(obj *objectfile.OBJ) error {
+	_, err := processor.Run(context.Background(), obj, newProgressReporter(processor.Name()))
+	return err
+}
+
+func (processor Merge) Run(ctx context.Context, obj *objectfile.OBJ, progress ProgressReporter) (interface{}, error) {
 	// use an array to preserve original order and
 	// to produce always the same output with same input.
 	// Map will 'randomize' keys in golang on each run.
@@ -50,6 +67,14 @@ func (processor Merge) Execute(obj *objectfile.OBJ) error {
 	}
 	logInfo("  - Found %d unique materials", len(materials))
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result := &MergeResult{
+		UniqueMaterials: len(materials),
+		ObjectsIn:       len(obj.Objects),
+	}
+
 	mergeName := func(objects []*objectfile.Object) string {
 		parts := []string{}
 		for _, child := range objects {
@@ -100,6 +125,7 @@ func (processor Merge) Execute(obj *objectfile.OBJ) error {
 			child.VertexData = append(child.VertexData, original.VertexData...)
 		}
 	}
+	result.ObjectsOut = len(obj.Objects)
 
-	return nil
+	return result, nil
 }
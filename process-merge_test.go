@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func BenchmarkMerge(b *testing.B) {
+	data := generateForBenchmark(b, 100000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		obj, _, err := parse(bytes.NewReader(data), "generated.obj")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if _, err := (Merge{}).Run(context.Background(), obj, silentProgress{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+type Quantize struct{}
+
+func (processor Quantize) Name() string {
+	return "Quantize"
+}
+
+func (processor Quantize) Desc() string {
+	return "Snaps v/vt declarations to the -quantize-v/-quantize-vt grid. Values equal after snapping are merged by Duplicates."
+}
+
+func (processor Quantize) Execute(obj *objectfile.OBJ) error {
+	report := Report.Processor(processor.Name())
+	for _, t := range []objectfile.Type{objectfile.Vertex, objectfile.UV} {
+		grid := StartParams.QuantizeV
+		if t == objectfile.UV {
+			grid = StartParams.QuantizeVT
+		}
+		if grid <= 0 {
+			continue
+		}
+		changed, quantize := 0, quantizer(grid)
+		for _, gv := range obj.Geometry.Get(t) {
+			x, y, z := quantize(gv.X), quantize(gv.Y), quantize(gv.Z)
+			if x != gv.X || y != gv.Y || z != gv.Z {
+				changed++
+			}
+			gv.X, gv.Y, gv.Z = x, y, z
+		}
+		logInfo("  - %-2s %7d values changed with grid %s", t, changed, strconv.FormatFloat(grid, 'g', -1, 64))
+		report.Set(t.String(), map[string]interface{}{
+			"grid":    grid,
+			"changed": changed,
+		})
+	}
+	return nil
+}
+
+// Returns a func that snaps values to the closest multiple of grid. The result is
+// rounded to the decimals of grid to avoid values like 0.30000000000000004 for 3 * 0.1.
+func quantizer(grid float64) func(float64) float64 {
+	decimals := 0
+	if str := strconv.FormatFloat(grid, 'f', -1, 64); strings.Contains(str, ".") {
+		decimals = len(str) - strings.Index(str, ".") - 1
+	}
+	return func(f float64) float64 {
+		q := math.Round(f/grid) * grid
+		if rounded, err := strconv.ParseFloat(strconv.FormatFloat(q, 'f', decimals, 64), 64); err == nil {
+			q = rounded
+		}
+		// no -0
+		if q == 0 {
+			q = 0
+		}
+		return q
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Generated geometry values lie on a grid with noise below the duplicate epsilon,
+// so values that round to the same grid point must be merged into one.
+const testGrid = 64
+
+// Generates an OBJ with duplicate geometry, objects and groups, usemtl switches,
+// absolute and relative indexes, 3-5 corner faces, lines and points.
+func generateTestOBJ(rng *rand.Rand, epsilon float64) []byte {
+	var b bytes.Buffer
+	value := func(components int) string {
+		parts := make([]string, components)
+		for i := range parts {
+			f := float64(rng.Intn(8*testGrid)-4*testGrid)/testGrid + (rng.Float64()*2-1)*epsilon/4
+			parts[i] = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return strings.Join(parts, " ")
+	}
+	declare := func(t objectfile.Type, components, n int) int {
+		declared := 0
+		for declared < n {
+			// unique value followed by 0-2 near duplicates
+			v := value(components)
+			for copies := rng.Intn(3); copies >= 0 && declared < n; copies-- {
+				fields := strings.Fields(v)
+				for i, field := range fields {
+					f, _ := strconv.ParseFloat(field, 64)
+					grid := math.Round(f*testGrid) / testGrid
+					fields[i] = strconv.FormatFloat(grid+(rng.Float64()*2-1)*epsilon/4, 'g', -1, 64)
+				}
+				fmt.Fprintf(&b, "%s %s\n", t, strings.Join(fields, " "))
+				declared++
+			}
+		}
+		return declared
+	}
+	nv := declare(objectfile.Vertex, 3, 3+rng.Intn(60))
+	nvt := declare(objectfile.UV, 2, 1+rng.Intn(20))
+	nvn := declare(objectfile.Normal, 3, 1+rng.Intn(20))
+
+	index := func(n int) string {
+		i := 1 + rng.Intn(n)
+		if rng.Intn(3) == 0 {
+			return strconv.Itoa(i - n - 1)
+		}
+		return strconv.Itoa(i)
+	}
+	for o := rng.Intn(5); o >= 0; o-- {
+		if rng.Intn(2) == 0 {
+			fmt.Fprintf(&b, "o object%d\n", o)
+		} else {
+			fmt.Fprintf(&b, "g group%d\n", o)
+		}
+		layout := rng.Intn(4)
+		for e := 1 + rng.Intn(20); e > 0; e-- {
+			if rng.Intn(6) == 0 {
+				fmt.Fprintf(&b, "usemtl material%d\n", rng.Intn(3))
+			}
+			switch rng.Intn(10) {
+			case 0:
+				fmt.Fprintf(&b, "l %s %s\n", index(nv), index(nv))
+			case 1:
+				fmt.Fprintf(&b, "p %s\n", index(nv))
+			default:
+				corners := make([]string, 3+rng.Intn(3))
+				for i := range corners {
+					switch layout {
+					case 0:
+						corners[i] = index(nv)
+					case 1:
+						corners[i] = index(nv) + "/" + index(nvt)
+					case 2:
+						corners[i] = index(nv) + "//" + index(nvn)
+					default:
+						corners[i] = index(nv) + "/" + index(nvt) + "/" + index(nvn)
+					}
+				}
+				fmt.Fprintf(&b, "f %s\n", strings.Join(corners, " "))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// Returns the rendered triangles, lines and points with values snapped to
+// the test grid and the number of each. Fails if a value is not within
+// epsilon of its grid point.
+func renderedElements(t *testing.T, obj *objectfile.OBJ, epsilon float64) map[string]int {
+	t.Helper()
+	key := func(gv *objectfile.GeometryValue, components int) string {
+		if gv == nil {
+			return "-"
+		}
+		parts := make([]string, components)
+		for i, f := range []float64{gv.X, gv.Y, gv.Z}[:components] {
+			grid := math.Round(f * testGrid)
+			if math.Abs(f-grid/testGrid) > epsilon {
+				t.Fatalf("value %g is not within %g of %g", f, epsilon, grid/testGrid)
+			}
+			parts[i] = strconv.Itoa(int(grid))
+		}
+		return strings.Join(parts, ",")
+	}
+	corner := func(decl *objectfile.Declaration) string {
+		return key(decl.RefVertex, 3) + "/" + key(decl.RefUV, 2) + "/" + key(decl.RefNormal, 3)
+	}
+	elements := make(map[string]int)
+	for _, child := range obj.Objects {
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				// zero length segments are not rendered, the writer drops them
+				var corners []string
+				for _, decl := range vd.Declarations {
+					if c := corner(decl); len(corners) == 0 || corners[len(corners)-1] != c {
+						corners = append(corners, c)
+					}
+				}
+				elements[child.Material+" "+vd.Type.String()+" "+strings.Join(corners, " ")]++
+				continue
+			}
+			for i := 2; i < len(vd.Declarations); i++ {
+				elements[child.Material+" f "+corner(vd.Declarations[0])+" "+corner(vd.Declarations[i-1])+" "+corner(vd.Declarations[i])]++
+			}
+		}
+	}
+	return elements
+}
+
+// parse -> Duplicates -> Merge -> write -> parse renders the same
+// elements with every index in bounds.
+func TestDuplicatesMergeRoundTrip(t *testing.T) {
+	iterations := 200
+	if testing.Short() {
+		iterations = 20
+	}
+	epsilon := newDuplicates().options.Epsilon
+	for seed := int64(1); seed <= int64(iterations); seed++ {
+		data := generateTestOBJ(rand.New(rand.NewSource(seed)), epsilon)
+		obj, _, err := parse(bytes.NewReader(data), "generated.obj")
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		checkReferences(t, obj)
+		want := renderedElements(t, obj, epsilon)
+		unique := obj.Geometry.Stats()
+
+		for _, p := range []ContextProcessor{newDuplicates(), Merge{}} {
+			if _, err := p.Run(context.Background(), obj, silentProgress{}); err != nil {
+				t.Fatalf("seed %d: %s: %s", seed, p.Name(), err)
+			}
+			checkReferences(t, obj)
+		}
+		if stats := obj.Geometry.Stats(); stats.Vertices > unique.Vertices || stats.UVs > unique.UVs || stats.Normals > unique.Normals {
+			t.Fatalf("seed %d: geometry grew from %#v to %#v", seed, unique, stats)
+		}
+
+		var out bytes.Buffer
+		if _, err := (ObjEncoder{}).Encode(&out, obj, ""); err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		result, _, err := parse(&out, "generated.obj")
+		if err != nil {
+			t.Fatalf("seed %d: written output does not parse: %s", seed, err)
+		}
+		checkReferences(t, result)
+		got := renderedElements(t, result, epsilon)
+
+		for element, n := range want {
+			if got[element] != n {
+				t.Fatalf("seed %d: %q rendered %d times, want %d", seed, element, got[element], n)
+			}
+		}
+		for element, n := range got {
+			if want[element] != n {
+				t.Fatalf("seed %d: %q rendered %d times, want %d", seed, element, n, want[element])
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// ProgressReporter shows the progress of long running processor tasks.
+type ProgressReporter interface {
+	// Returns a tracker for a task of total units. Tasks tracked before Start are shown together.
+	Track(name string, total int) ProgressTracker
+	// Starts showing the tracked tasks. Returns false if progress is not shown, processors
+	// should then log results as they become available so the user knows something is happening.
+	Start() bool
+	// Stops showing the tasks.
+	Stop()
+}
+
+// ProgressTracker is safe for concurrent use.
+type ProgressTracker interface {
+	Increment()
+	// Restarts the tracker for the next phase of the task.
+	Reset(name string, total int)
+}
+
+var progressModes = []string{"bar", "json", "none"}
+
+// Returns the -progress reporter for a processor step.
+func newProgressReporter(step string) ProgressReporter {
+	switch {
+	case StartParams.NoProgress || StartParams.Progress == "none":
+		return silentProgress{}
+	case StartParams.Progress == "json":
+		return &jsonProgress{step: step}
+	}
+	return &barProgress{}
+}
+
+// silentProgress
+
+type silentProgress struct{}
+
+func (silentProgress) Track(name string, total int) ProgressTracker {
+	return silentProgress{}
+}
+
+func (silentProgress) Start() bool {
+	return false
+}
+
+func (silentProgress) Stop() {}
+
+func (silentProgress) Increment() {}
+
+func (silentProgress) Reset(name string, total int) {}
+
+// barProgress shows the tasks as terminal progress bars.
+
+type barProgress struct {
+	bars []*pb.ProgressBar
+	pool *pb.Pool
+}
+
+type barTracker struct {
+	*pb.ProgressBar
+}
+
+func (p *barProgress) Track(name string, total int) ProgressTracker {
+	bar := pb.New(total).Prefix(name + "    ").SetMaxWidth(130)
+	bar.ShowTimeLeft = false
+	p.bars = append(p.bars, bar)
+	return barTracker{bar}
+}
+
+func (p *barProgress) Start() bool {
+	pool, err := pb.StartPool(p.bars...)
+	if err != nil {
+		// progress pools do not work in all shells on windows (eg. liteide)
+		return false
+	}
+	p.pool = pool
+	return true
+}
+
+func (p *barProgress) Stop() {
+	if p.pool != nil {
+		p.pool.Stop()
+	}
+}
+
+func (t barTracker) Increment() {
+	t.ProgressBar.Increment()
+}
+
+func (t barTracker) Reset(name string, total int) {
+	t.Prefix(name + "    ")
+	t.Total = int64(total)
+	t.Set(0)
+}
+
+// jsonProgress writes an event per line to stderr when a task
+// starts, advances by a percent and finishes.
+
+type jsonProgress struct {
+	step     string
+	trackers []*jsonTracker
+}
+
+type jsonTracker struct {
+	step string
+
+	mu      sync.Mutex
+	name    string
+	total   int64
+	done    int64
+	percent int64
+}
+
+type jsonProgressEvent struct {
+	Event   string `json:"event"`
+	Step    string `json:"step"`
+	Task    string `json:"task"`
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total"`
+	Percent int64  `json:"percent"`
+	Time    string `json:"time"`
+}
+
+var jsonProgressMutex sync.Mutex
+
+func (p *jsonProgress) Track(name string, total int) ProgressTracker {
+	t := &jsonTracker{step: p.step, name: name, total: int64(total)}
+	p.trackers = append(p.trackers, t)
+	return t
+}
+
+func (p *jsonProgress) Start() bool {
+	for _, t := range p.trackers {
+		t.emit("start", 0)
+	}
+	return true
+}
+
+func (p *jsonProgress) Stop() {
+	for _, t := range p.trackers {
+		t.emit("done", atomic.LoadInt64(&t.done))
+	}
+}
+
+// Called from multiple workers for every unit, the lock is only taken when the percent changes.
+func (t *jsonTracker) Increment() {
+	done := atomic.AddInt64(&t.done, 1)
+	total := atomic.LoadInt64(&t.total)
+	if total <= 0 || done*100/total <= atomic.LoadInt64(&t.percent) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if percent := done * 100 / t.total; percent > t.percent {
+		atomic.StoreInt64(&t.percent, percent)
+		t.emitLocked("progress", done)
+	}
+}
+
+func (t *jsonTracker) Reset(name string, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.name = name
+	atomic.StoreInt64(&t.done, 0)
+	atomic.StoreInt64(&t.percent, 0)
+	atomic.StoreInt64(&t.total, int64(total))
+	t.emitLocked("start", 0)
+}
+
+func (t *jsonTracker) emit(event string, done int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emitLocked(event, done)
+}
+
+func (t *jsonTracker) emitLocked(event string, done int64) {
+	e := jsonProgressEvent{
+		Event: event,
+		Step:  t.step,
+		Task:  t.name,
+		Done:  done,
+		Total: t.total,
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if t.total > 0 {
+		e.Percent = done * 100 / t.total
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	// stdout may be the -stdout output
+	jsonProgressMutex.Lock()
+	os.Stderr.Write(append(b, '\n'))
+	jsonProgressMutex.Unlock()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// Bump when fields are renamed, removed or change meaning.
+// Adding new fields does not require a version bump.
+const ReportSchemaVersion = 1
+
+var (
+	Report = newRunReport()
+)
+
+// runReport is the machine readable document written by -report.
+type runReport struct {
+	SchemaVersion int    `json:"schema_version"`
+	Application   string `json:"application"`
+	Version       string `json:"version"`
+	Started       string `json:"started"`
+
+	Params     reportParams       `json:"params"`
+	Timings    []reportTiming     `json:"timings"`
+	Steps      []reportStep       `json:"steps"`
+	Before     reportStats        `json:"before"`
+	After      reportStats        `json:"after"`
+	Processors []*reportProcessor `json:"processors"`
+	Files      reportFiles        `json:"files"`
+	Verify     *CompareResult     `json:"verify,omitempty"`
+	Warnings   []string           `json:"warnings"`
+
+	mWarnings sync.Mutex
+}
+
+// reportParams are the options of the run. Processor and writer options are
+// keyed by their flag names in snake_case eg. "epsilon_v", not by Go field names.
+type reportParams struct {
+	Input          string   `json:"input"`
+	Output         string   `json:"output"`
+	Format         string   `json:"format"`
+	Config         string   `json:"config,omitempty"`
+	Preset         string   `json:"preset,omitempty"`
+	Pipeline       []string `json:"pipeline"`
+	Workers        int      `json:"workers"`
+	Strict         bool     `json:"strict"`
+	TimeoutSeconds float64  `json:"timeout_seconds"`
+	Verify         bool     `json:"verify"`
+
+	// processor name to options
+	Processors    map[string]map[string]interface{} `json:"processors"`
+	Writer        map[string]interface{}            `json:"writer"`
+	VerifyOptions map[string]interface{}            `json:"verify_options,omitempty"`
+	Plugins       map[string]string                 `json:"plugins,omitempty"`
+}
+
+type reportTiming struct {
+	Step    string  `json:"step"`
+	Seconds float64 `json:"seconds"`
+}
+
+// reportStep is a processor run, processors can be in the -pipeline multiple times.
+type reportStep struct {
+	Step    string      `json:"step"`
+	Seconds float64     `json:"seconds"`
+	After   reportStats `json:"after"`
+	Diff    reportStats `json:"diff"`
+	Details interface{} `json:"details"`
+}
+
+type reportStats struct {
+	Objects  int `json:"objects"`
+	Groups   int `json:"groups"`
+	Faces    int `json:"faces"`
+	Lines    int `json:"lines"`
+	Points   int `json:"points"`
+	Vertices int `json:"vertices"`
+	Normals  int `json:"normals"`
+	UVs      int `json:"uvs"`
+	Params   int `json:"params"`
+}
+
+type reportProcessor struct {
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+	// Result of a ContextProcessor or the values set with Set.
+	Details interface{} `json:"details"`
+}
+
+type reportFiles struct {
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+	InputBytes  int64  `json:"input_bytes"`
+	OutputBytes int64  `json:"output_bytes"`
+	LinesInput  int    `json:"lines_input"`
+	LinesOutput int    `json:"lines_output"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		SchemaVersion: ReportSchemaVersion,
+		Application:   ApplicationName,
+		Timings:       make([]reportTiming, 0),
+		Steps:         make([]reportStep, 0),
+		Processors:    make([]*reportProcessor, 0),
+		Warnings:      make([]string, 0),
+	}
+}
+
+// Returns the report params from StartParams and the registered flags.
+func newReportParams() reportParams {
+	snakeCase := func(options map[string]interface{}) map[string]interface{} {
+		out := make(map[string]interface{}, len(options))
+		for name, value := range options {
+			out[strings.ReplaceAll(name, "-", "_")] = value
+		}
+		return out
+	}
+	config := effectiveConfig()
+	params := reportParams{
+		Input:          StartParams.Input,
+		Output:         StartParams.Output,
+		Format:         config.Format,
+		Config:         StartParams.Config,
+		Preset:         StartParams.Preset,
+		Pipeline:       config.Pipeline,
+		Workers:        StartParams.Workers,
+		Strict:         StartParams.Strict,
+		TimeoutSeconds: StartParams.Timeout.Seconds(),
+		Verify:         StartParams.Verify,
+		Processors:     make(map[string]map[string]interface{}),
+		Writer:         snakeCase(config.Writer),
+		Plugins:        config.Plugins,
+	}
+	if params.Pipeline == nil {
+		params.Pipeline = make([]string, 0)
+	}
+	for name, options := range config.Processors {
+		params.Processors[name] = snakeCase(options)
+	}
+	if StartParams.Verify {
+		options := make(map[string]interface{})
+		for _, name := range optionNames(&StartParams.VerifyOptions) {
+			options[name] = flag.Lookup(name).Value.(flag.Getter).Get()
+		}
+		params.VerifyOptions = snakeCase(options)
+	}
+	return params
+}
+
+func newReportStats(stats objectfile.ObjStats) reportStats {
+	return reportStats{
+		Objects:  stats.Objects,
+		Groups:   stats.Groups,
+		Faces:    stats.Faces,
+		Lines:    stats.Lines,
+		Points:   stats.Points,
+		Vertices: stats.Geometry.Vertices,
+		Normals:  stats.Geometry.Normals,
+		UVs:      stats.Geometry.UVs,
+		Params:   stats.Geometry.Params,
+	}
+}
+
+// Returns the change from before to rs.
+func (rs reportStats) Sub(before reportStats) reportStats {
+	return reportStats{
+		Objects:  rs.Objects - before.Objects,
+		Groups:   rs.Groups - before.Groups,
+		Faces:    rs.Faces - before.Faces,
+		Lines:    rs.Lines - before.Lines,
+		Points:   rs.Points - before.Points,
+		Vertices: rs.Vertices - before.Vertices,
+		Normals:  rs.Normals - before.Normals,
+		UVs:      rs.UVs - before.UVs,
+		Params:   rs.Params - before.Params,
+	}
+}
+
+func (r *runReport) AddStep(step reportStep) {
+	r.Steps = append(r.Steps, step)
+}
+
+func (r *runReport) AddTiming(step string, d time.Duration) {
+	r.Timings = append(r.Timings, reportTiming{Step: step, Seconds: d.Seconds()})
+}
+
+func (r *runReport) AddWarning(warning string) {
+	r.mWarnings.Lock()
+	defer r.mWarnings.Unlock()
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// Returns the processor entry for name, creating it on first call.
+func (r *runReport) Processor(name string) *reportProcessor {
+	for _, p := range r.Processors {
+		if p.Name == name {
+			return p
+		}
+	}
+	p := &reportProcessor{
+		Name:    name,
+		Details: make(map[string]interface{}),
+	}
+	r.Processors = append(r.Processors, p)
+	return p
+}
+
+func (p *reportProcessor) Set(key string, value interface{}) {
+	details, ok := p.Details.(map[string]interface{})
+	if !ok {
+		details = make(map[string]interface{})
+		p.Details = details
+	}
+	details[key] = value
+}
+
+func (r *runReport) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
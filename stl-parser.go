@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// STL declares every triangle with its own three vertices and a facet normal.
+// Geometry is read as is, Duplicates welds the shared vertices and normals.
+
+// Returns the number of lines parsed for ASCII and the number of triangles for binary files.
+func parseSTL(src io.Reader, name string) (*objectfile.OBJ, int, error) {
+	r := bufio.NewReaderSize(src, 64*1024)
+	if isASCIISTL(r) {
+		return parseASCIISTL(r, name)
+	}
+	return parseBinarySTL(r, name)
+}
+
+// Binary files can also start with "solid", check that the
+// beginning of the file looks like ASCII STL statements.
+func isASCIISTL(r *bufio.Reader) bool {
+	head, _ := r.Peek(1024)
+	if !bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte("solid")) {
+		return false
+	}
+	for _, b := range head {
+		if b > 127 || (b < 32 && b != '\n' && b != '\r' && b != '\t') {
+			return false
+		}
+	}
+	return bytes.Contains(head, []byte("facet")) || bytes.Contains(head, []byte("endsolid"))
+}
+
+func parseBinarySTL(r io.Reader, name string) (*objectfile.OBJ, int, error) {
+	dest := objectfile.NewOBJ()
+	child := dest.CreateObject(objectfile.ChildObject, fileBasename(name), "")
+
+	header := make([]byte, 84)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, fmt.Errorf("Invalid binary STL header: %s", err)
+	}
+	// 80 byte free form header followed by the triangle count
+	numTriangles := int(binary.LittleEndian.Uint32(header[80:]))
+
+	record := make([]byte, 50)
+	readVector := func(offset int) objectfile.Vector {
+		return objectfile.Vector{
+			X: float64(math.Float32frombits(binary.LittleEndian.Uint32(record[offset:]))),
+			Y: float64(math.Float32frombits(binary.LittleEndian.Uint32(record[offset+4:]))),
+			Z: float64(math.Float32frombits(binary.LittleEndian.Uint32(record[offset+8:]))),
+		}
+	}
+	for i := 0; i < numTriangles; i++ {
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, i, fmt.Errorf("triangle:%d of %d %s", i+1, numTriangles, err)
+		}
+		// normal, 3 vertices and a 2 byte attribute count that is ignored
+		if err := addSTLFacet(dest.Geometry, child, readVector(0), []objectfile.Vector{readVector(12), readVector(24), readVector(36)}); err != nil {
+			return nil, i, err
+		}
+	}
+	return dest, numTriangles, nil
+}
+
+func parseASCIISTL(r io.Reader, name string) (*objectfile.OBJ, int, error) {
+	var (
+		dest     = objectfile.NewOBJ()
+		scanner  = bufio.NewScanner(r)
+		linenum  = 0
+		child    *objectfile.Object
+		normal   objectfile.Vector
+		vertices []objectfile.Vector
+	)
+	parseVector := func(values []string) (objectfile.Vector, error) {
+		if len(values) < 3 {
+			return objectfile.Vector{}, fmt.Errorf("Expected 3 components, found %d", len(values))
+		}
+		var v [3]float64
+		for i := range v {
+			num, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				return objectfile.Vector{}, err
+			}
+			v[i] = num
+		}
+		return objectfile.Vector{X: v[0], Y: v[1], Z: v[2]}, nil
+	}
+
+	for scanner.Scan() {
+		linenum++
+
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		switch strings.ToLower(parts[0]) {
+		case "solid":
+			// each solid is a separate object
+			solidName := strings.Join(parts[1:], " ")
+			if len(solidName) == 0 {
+				solidName = fileBasename(name)
+			}
+			child = dest.CreateObject(objectfile.ChildObject, solidName, "")
+		case "facet":
+			if len(parts) < 2 || strings.ToLower(parts[1]) != "normal" {
+				return nil, linenum, wrapErrorLine(fmt.Errorf("Expected \"facet normal\""), linenum)
+			}
+			n, err := parseVector(parts[2:])
+			if err != nil {
+				return nil, linenum, wrapErrorLine(err, linenum)
+			}
+			normal, vertices = n, vertices[:0]
+		case "vertex":
+			v, err := parseVector(parts[1:])
+			if err != nil {
+				return nil, linenum, wrapErrorLine(err, linenum)
+			}
+			vertices = append(vertices, v)
+		case "endfacet":
+			if child == nil {
+				child = dest.CreateObject(objectfile.ChildObject, fileBasename(name), "")
+			}
+			if err := addSTLFacet(dest.Geometry, child, normal, vertices); err != nil {
+				return nil, linenum, wrapErrorLine(err, linenum)
+			}
+		case "outer", "endloop", "endsolid":
+		default:
+			return nil, linenum, wrapErrorLine(fmt.Errorf("Unsupported STL line %q", scanner.Text()), linenum)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, linenum, err
+	}
+	return dest, linenum, nil
+}
+
+// Adds a face with its own vertices. Zero length normals are not declared,
+// many exporters write them and expect the reader to compute normals.
+func addSTLFacet(geom *objectfile.Geometry, child *objectfile.Object, normal objectfile.Vector, vertices []objectfile.Vector) error {
+	if len(vertices) < 3 {
+		return fmt.Errorf("Facet declares %d vertices, at least 3 required", len(vertices))
+	}
+	var refNormal *objectfile.GeometryValue
+	if normal.Length() > 0 {
+		var err error
+		if refNormal, err = geom.Append(objectfile.Normal, &objectfile.GeometryValue{X: normal.X, Y: normal.Y, Z: normal.Z}); err != nil {
+			return err
+		}
+	}
+	vd := &objectfile.VertexData{Type: objectfile.Face}
+	for _, v := range vertices {
+		refVertex, err := geom.Append(objectfile.Vertex, &objectfile.GeometryValue{X: v.X, Y: v.Y, Z: v.Z, W: 1})
+		if err != nil {
+			return err
+		}
+		vd.Declarations = append(vd.Declarations, objectfile.NewDeclaration(refVertex, nil, refNormal))
+	}
+	child.VertexData = append(child.VertexData, vd)
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// StlEncoder writes binary STL. STL has no materials, models that use more than one
+// material are written as one file per material eg. "model.stl" is written to
+// "model.wood.stl" and "model.metal.stl" so they can be printed as separate parts.
+type StlEncoder struct{}
+
+func (enc StlEncoder) Name() string {
+	return "stl"
+}
+
+func (enc StlEncoder) Desc() string {
+	return "Binary STL, one file per material"
+}
+
+func (enc StlEncoder) Extensions() []string {
+	return []string{".stl"}
+}
+
+// Writes all triangles to w, returns the number of triangles written.
+func (enc StlEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	return writeSTL(writer, buildMesh(obj), -1)
+}
+
+// Returns the number of triangles written to all files.
+func (enc StlEncoder) EncodeFiles(obj *objectfile.OBJ, path string) ([]string, int, error) {
+	m := buildMesh(obj)
+	if m.Skipped > 0 {
+		logWarn("STL does not support lines and points, %d declarations not written", m.Skipped)
+	}
+	if len(m.Materials) <= 1 {
+		written, err := writeFile(path, func(w io.Writer) (int, error) {
+			return writeSTL(w, m, -1)
+		})
+		return []string{path}, written, err
+	}
+	var (
+		// keep .gz at the end eg. "model.wood.stl.gz"
+		trimmed     = trimCompressionExtension(path)
+		compression = path[len(trimmed):]
+		ext         = filepath.Ext(trimmed)
+		base        = strings.TrimSuffix(trimmed, ext)
+		files       []string
+		written     = 0
+		used        = make(map[string]bool)
+	)
+	for material, name := range m.Materials {
+		if m.MaterialTriangles(material) == 0 {
+			logWarn("Material %q has no triangles, file not written", name)
+			continue
+		}
+		fileName := stlFileName(name)
+		if used[fileName] {
+			fileName += "_" + intToString(material)
+		}
+		used[fileName] = true
+		materialPath := base + "." + fileName + ext + compression
+		num, err := writeFile(materialPath, func(w io.Writer) (int, error) {
+			return writeSTL(w, m, material)
+		})
+		if err != nil {
+			return files, written, err
+		}
+		files = append(files, materialPath)
+		logInfo("Wrote %d triangles with material %q to %s", num, name, materialPath)
+		written += num
+	}
+	return files, written, nil
+}
+
+var stlInvalidFileNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func stlFileName(material string) string {
+	if name := stlInvalidFileNameChars.ReplaceAllString(material, "_"); len(strings.Trim(name, "_.")) > 0 {
+		return name
+	}
+	return "default"
+}
+
+// Writes the faces with material, all faces if material is -1. Facet normals
+// are computed from the positions, STL readers expect them to match the winding.
+func writeSTL(writer io.Writer, m *mesh, material int) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(wc)
+
+	numTriangles := m.MaterialTriangles(material)
+
+	// 80 byte header that must not start with "solid", readers would detect it as ASCII
+	header := make([]byte, 80)
+	if !StartParams.NoHeader {
+		comment := strings.Replace(headerComment(), "\n", " ", -1)
+		if strings.HasPrefix(strings.ToLower(comment), "solid") {
+			comment = "# " + comment
+		}
+		copy(header, comment)
+	}
+	w.Write(header)
+
+	var (
+		buf      [4]byte
+		writeU32 = func(v uint32) {
+			binary.LittleEndian.PutUint32(buf[:], v)
+			w.Write(buf[:])
+		}
+		writeVector = func(v objectfile.Vector) {
+			writeU32(math.Float32bits(float32(v.X)))
+			writeU32(math.Float32bits(float32(v.Y)))
+			writeU32(math.Float32bits(float32(v.Z)))
+		}
+	)
+	writeU32(uint32(numTriangles))
+	for _, face := range m.Faces {
+		if material != -1 && face.Material != material {
+			continue
+		}
+		for _, triangle := range face.Triangles() {
+			a, b, c := m.Positions[triangle[0]].Vector(), m.Positions[triangle[1]].Vector(), m.Positions[triangle[2]].Vector()
+			writeVector(b.Sub(a).Cross(c.Sub(a)).Normalize())
+			writeVector(a)
+			writeVector(b)
+			writeVector(c)
+			// attribute byte count
+			w.Write([]byte{0, 0})
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := wc.Close(); err != nil {
+		return 0, err
+	}
+	return numTriangles, nil
+}
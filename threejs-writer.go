@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// ThreejsEncoder writes the faces of all objects as a single three.js BufferGeometry
+// with one group per material. The JSON can be loaded with THREE.BufferGeometryLoader.
+//
+// With -json-bin the attribute and index arrays are written to a .bin file next to
+// the JSON and the JSON only describes their byte offsets, see README.md.
+type ThreejsEncoder struct{}
+
+func (enc ThreejsEncoder) Name() string {
+	return "threejs"
+}
+
+func (enc ThreejsEncoder) Desc() string {
+	return "three.js BufferGeometry JSON, typed arrays to a .bin file with -json-bin"
+}
+
+func (enc ThreejsEncoder) Extensions() []string {
+	return []string{".json"}
+}
+
+type threejsGeometry struct {
+	Metadata       threejsMetadata              `json:"metadata"`
+	Type           string                       `json:"type"`
+	Name           string                       `json:"name,omitempty"`
+	Buffer         string                       `json:"buffer,omitempty"`
+	ByteLength     int                          `json:"byteLength,omitempty"`
+	Data           threejsData                  `json:"data"`
+	UserData       threejsUserData              `json:"userData"`
+	attributeOrder []string                     // binary layout order
+	attributes     map[string]*threejsAttribute // same as Data.Attributes
+}
+
+type threejsMetadata struct {
+	Version   float64 `json:"version"`
+	Type      string  `json:"type"`
+	Generator string  `json:"generator,omitempty"`
+}
+
+type threejsData struct {
+	Attributes     map[string]*threejsAttribute `json:"attributes"`
+	Index          *threejsAttribute            `json:"index,omitempty"`
+	Groups         []threejsGroup               `json:"groups"`
+	BoundingSphere threejsSphere                `json:"boundingSphere"`
+}
+
+type threejsAttribute struct {
+	ItemSize   int           `json:"itemSize,omitempty"`
+	Type       string        `json:"type"`
+	Normalized *bool         `json:"normalized,omitempty"`
+	Array      *threejsArray `json:"array,omitempty"`
+	ByteOffset *int          `json:"byteOffset,omitempty"`
+	Count      int           `json:"count,omitempty"`
+	values     []float64     // Float32Array values
+	indexes    []uint32      // Uint16Array or Uint32Array values
+	ff         objectfile.FloatFormat
+}
+
+type threejsArray struct {
+	attribute *threejsAttribute
+}
+
+type threejsGroup struct {
+	Start         int `json:"start"`
+	Count         int `json:"count"`
+	MaterialIndex int `json:"materialIndex"`
+}
+
+type threejsSphere struct {
+	Center [3]float64 `json:"center"`
+	Radius float64    `json:"radius"`
+}
+
+type threejsUserData struct {
+	// Material names, groups index to this.
+	Materials []string `json:"materials"`
+}
+
+// Float arrays are loaded to Float32Array, by default they are written with the
+// shortest representation that round trips as float32.
+func (a *threejsArray) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	if a.attribute.indexes != nil {
+		for i, index := range a.attribute.indexes {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(intToString(int(index)))
+		}
+	} else {
+		for i, value := range a.attribute.values {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(a.attribute.ff.Format(value))
+		}
+	}
+	sb.WriteByte(']')
+	return []byte(sb.String()), nil
+}
+
+func (a *threejsAttribute) byteLength() int {
+	if a.indexes != nil {
+		if a.Type == "Uint16Array" {
+			return len(a.indexes) * 2
+		}
+		return len(a.indexes) * 4
+	}
+	return len(a.values) * 4
+}
+
+// Returns the number of vertices and triangles written.
+func (enc ThreejsEncoder) EncodeFiles(obj *objectfile.OBJ, path string) ([]string, int, error) {
+	geometry := newThreejsGeometry(obj, outputName(path))
+	files := []string{path}
+	if StartParams.JsonBin {
+		// "model.json" to "model.bin", keep .gz at the end
+		trimmed := trimCompressionExtension(path)
+		binPath := strings.TrimSuffix(trimmed, filepath.Ext(trimmed)) + ".bin" + path[len(trimmed):]
+		if _, err := writeFile(binPath, geometry.writeBinary); err != nil {
+			return nil, 0, err
+		}
+		geometry.Buffer = filepath.Base(binPath)
+		files = append(files, binPath)
+	}
+	if _, err := writeFile(path, geometry.writeJSON); err != nil {
+		return files, 0, err
+	}
+	return files, geometry.numElements(), nil
+}
+
+// Writes the JSON with embedded arrays, -json-bin needs a file path.
+func (enc ThreejsEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	geometry := newThreejsGeometry(obj, outputName(path))
+	if _, err := geometry.writeJSON(writer); err != nil {
+		return 0, err
+	}
+	return geometry.numElements(), nil
+}
+
+func newThreejsGeometry(obj *objectfile.OBJ, name string) *threejsGeometry {
+	m := buildMesh(obj)
+	if m.Skipped > 0 {
+		logWarn("three.js output does not support lines and points, %d declarations not written", m.Skipped)
+	}
+
+	g := &threejsGeometry{
+		Metadata: threejsMetadata{
+			Version: 4.6,
+			Type:    "BufferGeometry",
+		},
+		Type: "BufferGeometry",
+		Name: name,
+		Data: threejsData{
+			Attributes: make(map[string]*threejsAttribute),
+			Groups:     make([]threejsGroup, 0),
+		},
+		UserData: threejsUserData{
+			Materials: m.Materials,
+		},
+		attributes: make(map[string]*threejsAttribute),
+	}
+	if !StartParams.NoHeader {
+		g.Metadata.Generator = headerComment()
+	}
+
+	addAttribute := func(name string, itemSize int, t objectfile.Type, values func(i int) objectfile.Vector) {
+		ff := StartParams.FloatFormatFor(t)
+		ff.Float32 = true
+		attribute := &threejsAttribute{
+			ItemSize: itemSize,
+			Type:     "Float32Array",
+			values:   make([]float64, 0, len(m.Positions)*itemSize),
+			ff:       ff,
+		}
+		for i := range m.Positions {
+			v := values(i)
+			attribute.values = append(attribute.values, []float64{v.X, v.Y, v.Z}[0:itemSize]...)
+		}
+		g.attributes[name] = attribute
+		g.attributeOrder = append(g.attributeOrder, name)
+	}
+	addAttribute("position", 3, objectfile.Vertex, func(i int) objectfile.Vector {
+		return m.Positions[i].Vector()
+	})
+	if m.HasNormals {
+		addAttribute("normal", 3, objectfile.Normal, func(i int) objectfile.Vector {
+			return meshValue(m.Normals[i])
+		})
+	}
+	if m.HasUVs {
+		addAttribute("uv", 2, objectfile.UV, func(i int) objectfile.Vector {
+			return meshValue(m.UVs[i])
+		})
+	}
+	if m.HasColors {
+		addAttribute("color", 3, objectfile.Vertex, func(i int) objectfile.Vector {
+			// vertices without a color are white
+			if c := m.Positions[i].Color; c != nil {
+				return objectfile.Vector{X: c.R, Y: c.G, Z: c.B}
+			}
+			return objectfile.Vector{X: 1, Y: 1, Z: 1}
+		})
+	}
+
+	// triangles ordered by material, one group per material
+	index := &threejsAttribute{
+		Type:    "Uint32Array",
+		indexes: make([]uint32, 0, m.NumTriangles()*3),
+	}
+	if len(m.Positions) <= math.MaxUint16+1 {
+		index.Type = "Uint16Array"
+	}
+	for material := range m.Materials {
+		group := threejsGroup{Start: len(index.indexes), MaterialIndex: material}
+		for _, face := range m.Faces {
+			if face.Material != material {
+				continue
+			}
+			for _, triangle := range face.Triangles() {
+				index.indexes = append(index.indexes, uint32(triangle[0]), uint32(triangle[1]), uint32(triangle[2]))
+			}
+		}
+		if group.Count = len(index.indexes) - group.Start; group.Count > 0 {
+			g.Data.Groups = append(g.Data.Groups, group)
+		}
+	}
+	g.Data.Index = index
+
+	// bounding sphere of the bounding box, three.js uses it for frustum culling
+	bb := objectfile.NewBoundingBox()
+	for _, position := range m.Positions {
+		bb.Expand(position.Vector())
+	}
+	if !bb.Empty {
+		center := bb.Min.Add(bb.Size().Scale(0.5))
+		g.Data.BoundingSphere.Center = [3]float64{center.X, center.Y, center.Z}
+		for _, position := range m.Positions {
+			g.Data.BoundingSphere.Radius = math.Max(g.Data.BoundingSphere.Radius, position.Vector().Sub(center).Length())
+		}
+	}
+	return g
+}
+
+func (g *threejsGeometry) numElements() int {
+	return g.attributes["position"].numItems() + len(g.Data.Index.indexes)/3
+}
+
+// Number of vertices in a Float32Array attribute.
+func (a *threejsAttribute) numItems() int {
+	return len(a.values) / a.ItemSize
+}
+
+func (g *threejsGeometry) writeJSON(writer io.Writer) (int, error) {
+	normalized := false
+	for _, name := range g.attributeOrder {
+		attribute := g.attributes[name]
+		attribute.Normalized = &normalized
+		if len(g.Buffer) > 0 {
+			attribute.Array = nil
+		} else {
+			attribute.Array = &threejsArray{attribute: attribute}
+		}
+		g.Data.Attributes[name] = attribute
+	}
+	if len(g.Buffer) == 0 {
+		g.Data.Index.Array = &threejsArray{attribute: g.Data.Index}
+	}
+
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(wc)
+	b, err := json.Marshal(g)
+	if err != nil {
+		return 0, err
+	}
+	w.Write(b)
+	w.WriteByte('\n')
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return 1, wc.Close()
+}
+
+// Writes the Float32Array attributes followed by the index array. Float arrays
+// are a multiple of 4 bytes so every array starts at an aligned byte offset.
+func (g *threejsGeometry) writeBinary(writer io.Writer) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		w      = bufio.NewWriter(wc)
+		buf    [4]byte
+		offset = 0
+	)
+	for _, name := range g.attributeOrder {
+		attribute := g.attributes[name]
+		attribute.ByteOffset = intPtr(offset)
+		attribute.Count = attribute.numItems()
+		for _, value := range attribute.values {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(value)))
+			w.Write(buf[:])
+		}
+		offset += attribute.byteLength()
+	}
+	index := g.Data.Index
+	index.ByteOffset = intPtr(offset)
+	index.Count = len(index.indexes)
+	for _, value := range index.indexes {
+		if index.Type == "Uint16Array" {
+			binary.LittleEndian.PutUint16(buf[:], uint16(value))
+			w.Write(buf[0:2])
+		} else {
+			binary.LittleEndian.PutUint32(buf[:], value)
+			w.Write(buf[:])
+		}
+	}
+	g.ByteLength = offset + index.byteLength()
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return g.ByteLength, wc.Close()
+}
+
+func intPtr(i int) *int {
+	return &i
+}
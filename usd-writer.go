@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// UsdEncoder writes an USD ASCII stage. Each object is a Mesh prim with face-varying
+// normals and st primvars. Objects that the parser split by usemtl are written as
+// one Mesh with a GeomSubset per material. MTL materials are converted to
+// UsdPreviewSurface networks.
+type UsdEncoder struct{}
+
+func (enc UsdEncoder) Name() string {
+	return "usda"
+}
+
+func (enc UsdEncoder) Desc() string {
+	return "USD ASCII stage with UsdPreviewSurface materials"
+}
+
+func (enc UsdEncoder) Extensions() []string {
+	return []string{".usda"}
+}
+
+// usdMesh is an object with the objects that were split from it.
+type usdMesh struct {
+	Name    string
+	Objects []*objectfile.Object
+}
+
+func (enc UsdEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		bw      = bufio.NewWriter(wc)
+		lw      = &lineCountingWriter{w: bw}
+		root    = "/" + uniqueIdentifier(outputName(path), map[string]bool{})
+		indent  = ""
+		skipped = 0
+		line    = func(format string, args ...interface{}) {
+			if len(format) == 0 {
+				fmt.Fprint(lw, "\n")
+				return
+			}
+			fmt.Fprintf(lw, indent+format+"\n", args...)
+		}
+		begin = func(format string, args ...interface{}) {
+			line(format, args...)
+			line("{")
+			indent += "    "
+		}
+		end = func() {
+			indent = indent[4:]
+			line("}")
+		}
+	)
+
+	// materials: paths by OBJ material name
+	materialPaths := make(map[string]string)
+	materialIds := map[string]bool{}
+	var materials []string
+	for _, child := range obj.Objects {
+		if len(child.Material) > 0 && len(materialPaths[child.Material]) == 0 {
+			materialPaths[child.Material] = root + "/Materials/" + uniqueIdentifier(child.Material, materialIds)
+			materials = append(materials, child.Material)
+		}
+	}
+
+	// meshes: objects split by usemtl are written to the same mesh
+	var meshes []*usdMesh
+	meshIds := map[string]bool{"Materials": true}
+	byOrigin := make(map[*objectfile.Object]*usdMesh)
+	for _, child := range obj.Objects {
+		origin := child
+		if child.Origin != nil {
+			origin = child.Origin
+		}
+		mesh := byOrigin[origin]
+		if mesh == nil {
+			mesh = &usdMesh{Name: uniqueIdentifier(origin.Name, meshIds)}
+			byOrigin[origin] = mesh
+			meshes = append(meshes, mesh)
+		}
+		mesh.Objects = append(mesh.Objects, child)
+	}
+
+	line("#usda 1.0")
+	line("(")
+	line("    defaultPrim = %q", root[1:])
+	if !StartParams.NoHeader {
+		line("    doc = %q", headerComment())
+	}
+	line("    upAxis = \"Y\"")
+	line(")")
+	line("")
+	begin("def Xform %q (\n    kind = \"component\"\n)", root[1:])
+
+	var (
+		ffV  = StartParams.FloatFormatFor(objectfile.Vertex)
+		ffVN = StartParams.FloatFormatFor(objectfile.Normal)
+		ffVT = StartParams.FloatFormatFor(objectfile.UV)
+	)
+	for _, mesh := range meshes {
+		var (
+			counts, indexes    []string
+			points, colors     []string
+			normals, uvs       []string
+			hasNormals, hasUVs bool
+			local              = make(map[*objectfile.GeometryValue]int)
+			subsets            = make(map[string][]string)
+			subsetOrder        []string
+			face               = 0
+		)
+		for _, child := range mesh.Objects {
+			for _, vd := range child.VertexData {
+				if vd.Type != objectfile.Face {
+					skipped++
+					continue
+				}
+				counts = append(counts, intToString(len(vd.Declarations)))
+				for _, decl := range vd.Declarations {
+					index, ok := local[decl.RefVertex]
+					if !ok {
+						index = len(points)
+						local[decl.RefVertex] = index
+						points = append(points, usdTuple(ffV, decl.RefVertex.X, decl.RefVertex.Y, decl.RefVertex.Z))
+						if c := decl.RefVertex.Color; c != nil {
+							colors = append(colors, usdTuple(ffV, c.R, c.G, c.B))
+						} else {
+							colors = append(colors, usdTuple(ffV, 1, 1, 1))
+						}
+					}
+					indexes = append(indexes, intToString(index))
+					n, uv := meshValue(decl.RefNormal), meshValue(decl.RefUV)
+					normals = append(normals, usdTuple(ffVN, n.X, n.Y, n.Z))
+					uvs = append(uvs, usdTuple(ffVT, uv.X, uv.Y))
+					hasNormals = hasNormals || decl.RefNormal != nil
+					hasUVs = hasUVs || decl.RefUV != nil
+				}
+				if _, ok := subsets[child.Material]; !ok {
+					subsetOrder = append(subsetOrder, child.Material)
+				}
+				subsets[child.Material] = append(subsets[child.Material], intToString(face))
+				face++
+			}
+		}
+		if face == 0 {
+			continue
+		}
+
+		line("")
+		begin("def Mesh %q", mesh.Name)
+		line("int[] faceVertexCounts = [%s]", strings.Join(counts, ", "))
+		line("int[] faceVertexIndices = [%s]", strings.Join(indexes, ", "))
+		line("point3f[] points = [%s]", strings.Join(points, ", "))
+		if hasNormals {
+			line("normal3f[] normals = [%s] (\n%s    interpolation = \"faceVarying\"\n%s)", strings.Join(normals, ", "), indent, indent)
+		}
+		if hasUVs {
+			line("texCoord2f[] primvars:st = [%s] (\n%s    interpolation = \"faceVarying\"\n%s)", strings.Join(uvs, ", "), indent, indent)
+		}
+		if mesh.hasColors() {
+			line("color3f[] primvars:displayColor = [%s] (\n%s    interpolation = \"vertex\"\n%s)", strings.Join(colors, ", "), indent, indent)
+		}
+		line("uniform token subdivisionScheme = \"none\"")
+		if len(subsetOrder) == 1 {
+			if path := materialPaths[subsetOrder[0]]; len(path) > 0 {
+				line("rel material:binding = <%s>", path)
+			}
+		} else {
+			subsetIds := map[string]bool{}
+			line("uniform token subsetFamily:materialBind:familyType = \"partition\"")
+			for _, material := range subsetOrder {
+				line("")
+				begin("def GeomSubset %q", uniqueIdentifier(material, subsetIds))
+				line("uniform token elementType = \"face\"")
+				line("uniform token familyName = \"materialBind\"")
+				line("int[] indices = [%s]", strings.Join(subsets[material], ", "))
+				if path := materialPaths[material]; len(path) > 0 {
+					line("rel material:binding = <%s>", path)
+				}
+				end()
+			}
+		}
+		end()
+	}
+	if skipped > 0 {
+		logWarn("USD output does not support lines and points, %d declarations not written", skipped)
+	}
+
+	if len(materials) > 0 {
+		line("")
+		begin("def Scope \"Materials\"")
+		for i, name := range materials {
+			if i > 0 {
+				line("")
+			}
+			writeUsdMaterial(obj.Material(name), name, materialPaths[name], path, line, begin, end)
+		}
+		end()
+	}
+	end()
+
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return lw.lines, wc.Close()
+}
+
+func (mesh *usdMesh) hasColors() bool {
+	for _, child := range mesh.Objects {
+		for _, vd := range child.VertexData {
+			for _, decl := range vd.Declarations {
+				if decl.RefVertex != nil && decl.RefVertex.Color != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// UsdUVTexture shader that is connected to a PreviewSurface input.
+type usdTexture struct {
+	id, channel, outputType, file string
+}
+
+// Converts a MTL material to an UsdPreviewSurface network.
+func writeUsdMaterial(material *objectfile.Material, name, path, output string, line, begin func(string, ...interface{}), end func()) {
+	var (
+		ff       = objectfile.DefaultFloatFormat
+		shader   = path + "/PreviewSurface"
+		inputs   []string
+		textures []usdTexture
+	)
+	if material == nil {
+		logWarn("Material %q not found from the mtllib files, writing a default UsdPreviewSurface", name)
+		material = &objectfile.Material{Name: name}
+	}
+
+	color := func(input, key string) {
+		if values := material.Floats(key); len(values) >= 3 {
+			inputs = append(inputs, fmt.Sprintf("color3f inputs:%s = %s", input, usdTuple(ff, values[0], values[1], values[2])))
+		}
+	}
+	float := func(input string, value float64) {
+		inputs = append(inputs, fmt.Sprintf("float inputs:%s = %s", input, ff.Format(value)))
+	}
+	texture := func(input, key, channel, outputType string) {
+		p := material.Property(key)
+		if p == nil || len(p.TexturePath()) == 0 {
+			return
+		}
+		id := strings.TrimPrefix(input, "inputs:") + "Texture"
+		inputs = append(inputs, fmt.Sprintf("%s inputs:%s.connect = <%s/%s.outputs:%s>", outputType, input, path, id, channel))
+		textures = append(textures, usdTexture{id, channel, outputType, outputTexturePath(material, p.TexturePath(), output)})
+	}
+
+	color("diffuseColor", "Kd")
+	color("emissiveColor", "Ke")
+	if values := material.Floats("Ks"); len(values) >= 3 && material.Property("Pm") == nil {
+		color("specularColor", "Ks")
+		inputs = append(inputs, "int inputs:useSpecularWorkflow = 1")
+	}
+	if values := material.Floats("Pm"); len(values) > 0 {
+		float("metallic", values[0])
+	}
+	if values := material.Floats("Pr"); len(values) > 0 {
+		float("roughness", values[0])
+	} else if values := material.Floats("Ns"); len(values) > 0 {
+		// Blinn-Phong exponent to roughness
+		float("roughness", math.Sqrt(2/(math.Max(values[0], 0)+2)))
+	}
+	if values := material.Floats("d"); len(values) > 0 {
+		float("opacity", values[0])
+	} else if values := material.Floats("Tr"); len(values) > 0 {
+		float("opacity", 1-values[0])
+	}
+	if values := material.Floats("Ni"); len(values) > 0 {
+		float("ior", values[0])
+	}
+	texture("diffuseColor", "map_Kd", "rgb", "color3f")
+	texture("emissiveColor", "map_Ke", "rgb", "color3f")
+	texture("roughness", "map_Pr", "r", "float")
+	texture("metallic", "map_Pm", "r", "float")
+	texture("opacity", "map_d", "r", "float")
+	for _, key := range []string{"norm", "map_Bump", "bump"} {
+		if material.Property(key) != nil {
+			texture("normal", key, "rgb", "normal3f")
+			break
+		}
+	}
+
+	begin("def Material %q", path[strings.LastIndex(path, "/")+1:])
+	line("token outputs:surface.connect = <%s.outputs:surface>", shader)
+	line("")
+	begin("def Shader \"PreviewSurface\"")
+	line("uniform token info:id = \"UsdPreviewSurface\"")
+	for _, input := range inputs {
+		line("%s", input)
+	}
+	line("token outputs:surface")
+	end()
+	if len(textures) > 0 {
+		line("")
+		begin("def Shader \"stReader\"")
+		line("uniform token info:id = \"UsdPrimvarReader_float2\"")
+		line("string inputs:varname = \"st\"")
+		line("float2 outputs:result")
+		end()
+		for _, t := range textures {
+			outputType := t.outputType
+			if outputType == "color3f" || outputType == "normal3f" {
+				outputType = "float3"
+			}
+			line("")
+			begin("def Shader %q", t.id)
+			line("uniform token info:id = \"UsdUVTexture\"")
+			line("asset inputs:file = @%s@", t.file)
+			line("float2 inputs:st.connect = <%s/stReader.outputs:result>", path)
+			line("token inputs:wrapS = \"repeat\"")
+			line("token inputs:wrapT = \"repeat\"")
+			if t.id == "normalTexture" {
+				// normal maps are stored in 0..1, USD expects -1..1
+				line("float4 inputs:scale = (2, 2, 2, 1)")
+				line("float4 inputs:bias = (-1, -1, -1, 0)")
+			}
+			line("%s outputs:%s", outputType, t.channel)
+			end()
+		}
+	}
+	end()
+}
+
+func usdTuple(ff objectfile.FloatFormat, values ...float64) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = ff.Format(value)
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
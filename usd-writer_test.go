@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Texture paths are relative to the output file, not the working directory.
+func TestUsdTexturePath(t *testing.T) {
+	defer func(sp startParams) { StartParams = sp }(StartParams)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"a.obj": "mtllib a.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\nusemtl m\nf 1/1 2/1 3/1\n",
+		"a.mtl": "newmtl m\nmap_Kd tex.png\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "in", name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	StartParams.Input = filepath.Join(dir, "in", "a.obj")
+	obj, _, _, err := parseInput(StartParams.Input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := (UsdEncoder{}).Encode(&out, obj, filepath.Join(dir, "out", "a.usda")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "@../in/tex.png@") {
+		t.Fatalf("texture is not relative to the output:\n%s", out.String())
+	}
+}
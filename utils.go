@@ -71,6 +71,15 @@ func strContainsAny(str string, parts []string, cs caseSensitivity) bool {
 	return false
 }
 
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
 func substring(str string, i int, iEnd int) string {
 	strLen := len(str)
 	if i < 0 {
@@ -116,6 +125,11 @@ func fileExists(path string) bool {
 	return true
 }
 
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
 func fileBasename(path string) string {
 	p := cleanPath(path)
 	if p[len(p)-1] == '/' {
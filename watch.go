@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watcher polls the -in file or directory for changes. Polling is used instead of
+// file system notifications so that it works on network shares.
+type watcher struct {
+	// -in directory, empty when watching a single file
+	dir   string
+	files map[string]*watchedFile
+	// files written by the runs, changes to them are ignored
+	outputs map[string]bool
+	// mtllib base names declared by each model on its last run
+	mtllibs map[string][]string
+}
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	// when the last change was seen, the file is processed once it has
+	// not changed for -watch-debounce to skip partially written files.
+	changed time.Time
+	pending bool
+}
+
+// Watches until ctx is done.
+func watch(ctx context.Context) error {
+	w := &watcher{
+		files:   make(map[string]*watchedFile),
+		outputs: make(map[string]bool),
+		mtllibs: make(map[string][]string),
+	}
+	if info, err := os.Stat(StartParams.Input); err != nil {
+		return err
+	} else if info.IsDir() {
+		w.dir = StartParams.Input
+	}
+	logInfo("Watching %s for changes every %s, press Ctrl+C to stop", StartParams.Input, StartParams.WatchInterval)
+
+	// build all models once on start
+	w.poll(time.Time{})
+	for path, file := range w.files {
+		file.pending = isModelExtension(fileExtension(trimCompressionExtension(path)))
+	}
+	for {
+		if ready := w.ready(time.Now()); len(ready) > 0 {
+			w.process(ctx, ready)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(StartParams.WatchInterval):
+		}
+		w.poll(time.Now())
+	}
+}
+
+// Returns the files to watch: the -in file and its material libraries,
+// or the models and material libraries in the -in directory.
+func (w *watcher) paths() []string {
+	var paths []string
+	if len(w.dir) == 0 {
+		paths = append(paths, StartParams.Input)
+		for _, mtllib := range w.mtllibs[StartParams.Input] {
+			paths = append(paths, filepath.Join(filepath.Dir(StartParams.Input), mtllib))
+		}
+		return paths
+	}
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		logError("Failed to list %s: %s", w.dir, err)
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(w.dir, entry.Name())
+		if ext := fileExtension(trimCompressionExtension(path)); !isModelExtension(ext) && ext != ".mtl" {
+			continue
+		}
+		// default output names, in case they were written by a previous session
+		if w.outputs[path] || strings.Contains(entry.Name(), ".simplified.") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (w *watcher) poll(now time.Time) {
+	seen := make(map[string]bool)
+	for _, path := range w.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seen[path] = true
+		file := w.files[path]
+		if file == nil {
+			file = &watchedFile{}
+			w.files[path] = file
+		} else if file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+			continue
+		}
+		file.modTime, file.size = info.ModTime(), info.Size()
+		file.changed, file.pending = now, !now.IsZero()
+	}
+	for path := range w.files {
+		if !seen[path] {
+			delete(w.files, path)
+		}
+	}
+}
+
+// Returns the models to process for files that have not changed for -watch-debounce.
+// A changed material library reprocesses the models that declared it.
+func (w *watcher) ready(now time.Time) []string {
+	models := make(map[string]bool)
+	for path, file := range w.files {
+		if !file.pending || now.Sub(file.changed) < StartParams.WatchDebounce {
+			continue
+		}
+		file.pending = false
+		if isModelExtension(fileExtension(trimCompressionExtension(path))) {
+			models[path] = true
+			continue
+		}
+		for model, mtllibs := range w.mtllibs {
+			for _, mtllib := range mtllibs {
+				if filepath.Base(mtllib) == filepath.Base(path) {
+					models[model] = true
+				}
+			}
+		}
+	}
+	ready := make([]string, 0, len(models))
+	for path := range models {
+		ready = append(ready, path)
+	}
+	sort.Strings(ready)
+	return ready
+}
+
+// Runs the pipeline for each model and logs a one line summary per run.
+func (w *watcher) process(ctx context.Context, models []string) {
+	var (
+		input, output     = StartParams.Input, StartParams.Output
+		quiet, noProgress = StartParams.Quiet, StartParams.NoProgress
+	)
+	defer func() {
+		StartParams.Input, StartParams.Output = input, output
+		StartParams.Quiet, StartParams.NoProgress = quiet, noProgress
+	}()
+
+	for _, path := range models {
+		StartParams.Input = path
+		if len(w.dir) > 0 {
+			StartParams.Output = watchOutputPath(path, output)
+		}
+		StartParams.Quiet, StartParams.NoProgress = true, true
+		res, err := runPipeline(ctx)
+		StartParams.Quiet = quiet
+
+		prefix := time.Now().Format("15:04:05") + " " + filepath.Base(path)
+		if err != nil {
+			logError("%s: %s", prefix, err)
+			continue
+		}
+		for _, file := range res.OutputFiles {
+			w.outputs[file] = true
+		}
+		var mtllibs []string
+		for _, value := range res.MaterialLibraries {
+			mtllibs = append(mtllibs, strings.Fields(value)...)
+		}
+		w.mtllibs[path] = mtllibs
+
+		summary := fmt.Sprintf("%s -> %s | v %s | faces %s | %s -> %s | %s",
+			prefix, filepath.Base(res.Output),
+			watchDiff(res.Pre.Geometry.Vertices, res.Post.Geometry.Vertices),
+			watchDiff(res.Pre.Faces, res.Post.Faces),
+			formatBytes(res.SizeIn), formatBytes(res.SizeOut),
+			formatDuration(res.Duration))
+		if num := len(Report.Warnings); num > 0 {
+			summary += fmt.Sprintf(" | %d warnings", num)
+		}
+		logInfo("%s", summary)
+	}
+}
+
+func watchDiff(pre, post int) string {
+	if pre == post {
+		return formatInt(post)
+	}
+	return formatInt(pre) + " -> " + formatInt(post)
+}
+
+// Models in a watched directory are written to the -out directory with their
+// own name, or next to the input with the default output name. The .simplified
+// suffix is kept if -out is the watched directory so outputs don't retrigger the
+// watch. Inputs that are converted keep their extension, a.stl is written to
+// a.stl.obj so it does not overwrite the output of a.obj.
+func watchOutputPath(input, outDir string) string {
+	var (
+		output = defaultOutputPath(input)
+		dir    = filepath.Dir(output)
+		name   = filepath.Base(output)
+		ext    = filepath.Ext(trimCompressionExtension(input))
+		i      = strings.LastIndex(name, ".simplified")
+	)
+	if i == -1 {
+		return output
+	}
+	if !strings.EqualFold(ext, filepath.Ext(name)) {
+		name = name[0:i] + ext + name[i:]
+		i += len(ext)
+	}
+	if len(outDir) > 0 && !sameDir(outDir, dir) {
+		dir, name = outDir, name[0:i]+name[i+len(".simplified"):]
+	}
+	return filepath.Join(dir, name)
+}
+
+// Reports if a and b are the same directory.
+func sameDir(a, b string) bool {
+	if infoA, err := os.Stat(a); err == nil {
+		if infoB, err := os.Stat(b); err == nil {
+			return os.SameFile(infoA, infoB)
+		}
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchOutputPath(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	for _, tc := range []struct {
+		input, outDir, want string
+	}{
+		{filepath.Join(dir, "a.obj"), "", filepath.Join(dir, "a.simplified.obj")},
+		{filepath.Join(dir, "a.stl"), "", filepath.Join(dir, "a.stl.simplified.obj")},
+		{filepath.Join(dir, "a.obj"), out, filepath.Join(out, "a.obj")},
+		// converted inputs keep their extension, a.stl and a.ply must not overwrite a.obj
+		{filepath.Join(dir, "a.stl"), out, filepath.Join(out, "a.stl.obj")},
+		{filepath.Join(dir, "a.ply"), out, filepath.Join(out, "a.ply.obj")},
+		// -out is the watched directory, outputs keep the .simplified suffix
+		{filepath.Join(dir, "a.obj"), dir, filepath.Join(dir, "a.simplified.obj")},
+		{filepath.Join(dir, "a.stl"), dir, filepath.Join(dir, "a.stl.simplified.obj")},
+		{filepath.Join(dir, "a.stl"), dir + string(filepath.Separator) + ".", filepath.Join(dir, "a.stl.simplified.obj")},
+	} {
+		if got := watchOutputPath(tc.input, tc.outDir); got != tc.want {
+			t.Errorf("watchOutputPath(%q, %q) = %q, want %q", tc.input, tc.outDir, got, tc.want)
+		}
+	}
+}
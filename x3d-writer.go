@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jonnenauha/obj-simplify/objectfile"
+)
+
+// X3dEncoder writes X3D XML. X3D indexes positions, normals and texture coordinates
+// separately like OBJ, the geometry is declared once and each object is a Shape
+// with an IndexedFaceSet that USEs it.
+type X3dEncoder struct{}
+
+func (enc X3dEncoder) Name() string {
+	return "x3d"
+}
+
+func (enc X3dEncoder) Desc() string {
+	return "X3D 3.3 XML with an IndexedFaceSet per object"
+}
+
+func (enc X3dEncoder) Extensions() []string {
+	return []string{".x3d"}
+}
+
+func (enc X3dEncoder) Encode(writer io.Writer, obj *objectfile.OBJ, path string) (int, error) {
+	wc, err := compressWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		bw       = bufio.NewWriter(wc)
+		lw       = &lineCountingWriter{w: bw}
+		skipped  = 0
+		declared = make(map[objectfile.Type]bool)
+		// Appearance DEF names by material
+		appearances = make(map[string]string)
+		ids         = map[string]bool{"coords": true, "normals": true, "uvs": true}
+	)
+
+	fmt.Fprint(lw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprint(lw, "<!DOCTYPE X3D PUBLIC \"ISO//Web3D//DTD X3D 3.3//EN\" \"http://www.web3d.org/specifications/x3d-3.3.dtd\">\n")
+	fmt.Fprint(lw, "<X3D profile=\"Interchange\" version=\"3.3\">\n")
+	if !StartParams.NoHeader {
+		fmt.Fprintf(lw, "  <head>\n    <meta name=\"generator\" content=\"%s\"/>\n  </head>\n", xmlEscape(headerComment()))
+	}
+	fmt.Fprint(lw, "  <Scene>\n")
+
+	for _, child := range obj.Objects {
+		var (
+			coordIndex, normalIndex, uvIndex []string
+			hasNormals, hasUVs               = true, true
+		)
+		for _, vd := range child.VertexData {
+			if vd.Type != objectfile.Face {
+				skipped++
+				continue
+			}
+			for _, decl := range vd.Declarations {
+				// X3D indexes start from 0
+				coordIndex = append(coordIndex, intToString(decl.Index(objectfile.Vertex)-1))
+				normalIndex = append(normalIndex, intToString(decl.Index(objectfile.Normal)-1))
+				uvIndex = append(uvIndex, intToString(decl.Index(objectfile.UV)-1))
+				hasNormals = hasNormals && decl.RefNormal != nil
+				hasUVs = hasUVs && decl.RefUV != nil
+			}
+			coordIndex = append(coordIndex, "-1")
+			normalIndex = append(normalIndex, "-1")
+			uvIndex = append(uvIndex, "-1")
+		}
+		if len(coordIndex) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(lw, "    <Shape DEF=\"%s\">\n", uniqueIdentifier(child.Name, ids))
+		if len(child.Material) > 0 {
+			if def, ok := appearances[child.Material]; ok {
+				fmt.Fprintf(lw, "      <Appearance USE=\"%s\"/>\n", def)
+			} else {
+				def = uniqueIdentifier(child.Material, ids)
+				appearances[child.Material] = def
+				writeX3dAppearance(lw, def, child.Material, obj.Material(child.Material), path)
+			}
+		}
+		fmt.Fprintf(lw, "      <IndexedFaceSet solid=\"false\" coordIndex=\"%s\"", strings.Join(coordIndex, " "))
+		if hasNormals {
+			fmt.Fprintf(lw, " normalIndex=\"%s\"", strings.Join(normalIndex, " "))
+		}
+		if hasUVs {
+			fmt.Fprintf(lw, " texCoordIndex=\"%s\"", strings.Join(uvIndex, " "))
+		}
+		fmt.Fprint(lw, ">\n")
+		writeX3dGeometry(lw, obj, objectfile.Vertex, "Coordinate", "coords", "point", declared)
+		if hasNormals {
+			writeX3dGeometry(lw, obj, objectfile.Normal, "Normal", "normals", "vector", declared)
+		}
+		if hasUVs {
+			writeX3dGeometry(lw, obj, objectfile.UV, "TextureCoordinate", "uvs", "point", declared)
+		}
+		fmt.Fprint(lw, "      </IndexedFaceSet>\n")
+		fmt.Fprint(lw, "    </Shape>\n")
+	}
+	if skipped > 0 {
+		logWarn("X3D output does not support lines and points, %d declarations not written", skipped)
+	}
+
+	fmt.Fprint(lw, "  </Scene>\n")
+	fmt.Fprint(lw, "</X3D>\n")
+
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return lw.lines, wc.Close()
+}
+
+// Writes the geometry node with all values on first use, later uses reference it.
+func writeX3dGeometry(w io.Writer, obj *objectfile.OBJ, t objectfile.Type, node, def, field string, declared map[objectfile.Type]bool) {
+	if declared[t] {
+		fmt.Fprintf(w, "        <%s USE=\"%s\"/>\n", node, def)
+		return
+	}
+	declared[t] = true
+	ff := StartParams.FloatFormatFor(t)
+	values := make([]string, 0, len(obj.Geometry.Get(t)))
+	for _, gv := range obj.Geometry.Get(t) {
+		if t == objectfile.UV {
+			values = append(values, ff.Format(gv.X)+" "+ff.Format(gv.Y))
+		} else {
+			values = append(values, ff.Format(gv.X)+" "+ff.Format(gv.Y)+" "+ff.Format(gv.Z))
+		}
+	}
+	fmt.Fprintf(w, "        <%s DEF=\"%s\" %s=\"%s\"/>\n", node, def, field, strings.Join(values, ", "))
+}
+
+func writeX3dAppearance(w io.Writer, def, name string, material *objectfile.Material, output string) {
+	fmt.Fprintf(w, "      <Appearance DEF=\"%s\">\n", def)
+	if material == nil {
+		logWarn("Material %q not found from the mtllib files, writing a default X3D Material", name)
+		fmt.Fprint(w, "        <Material/>\n")
+	} else {
+		var (
+			ff    = objectfile.DefaultFloatFormat
+			attrs []string
+		)
+		color := func(attr, key string) {
+			if values := material.Floats(key); len(values) >= 3 {
+				attrs = append(attrs, fmt.Sprintf("%s=\"%s %s %s\"", attr, ff.Format(values[0]), ff.Format(values[1]), ff.Format(values[2])))
+			}
+		}
+		color("diffuseColor", "Kd")
+		color("emissiveColor", "Ke")
+		color("specularColor", "Ks")
+		if values := material.Floats("Ns"); len(values) > 0 {
+			// MTL exponent is 0-1000, X3D shininess 0-1
+			attrs = append(attrs, fmt.Sprintf("shininess=\"%s\"", ff.Format(clamp(values[0]/1000, 0, 1))))
+		}
+		if values := material.Floats("d"); len(values) > 0 {
+			attrs = append(attrs, fmt.Sprintf("transparency=\"%s\"", ff.Format(1-values[0])))
+		} else if values := material.Floats("Tr"); len(values) > 0 {
+			attrs = append(attrs, fmt.Sprintf("transparency=\"%s\"", ff.Format(values[0])))
+		}
+		fmt.Fprintf(w, "        <Material %s/>\n", strings.Join(attrs, " "))
+		if p := material.Property("map_Kd"); p != nil && len(p.TexturePath()) > 0 {
+			fmt.Fprintf(w, "        <ImageTexture url='\"%s\"'/>\n", xmlEscape(outputTexturePath(material, p.TexturePath(), output)))
+		}
+	}
+	fmt.Fprint(w, "      </Appearance>\n")
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func clamp(f, min, max float64) float64 {
+	if f < min {
+		return min
+	}
+	if f > max {
+		return max
+	}
+	return f
+}